@@ -0,0 +1,213 @@
+package api
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	toxy "github.com/Shopify/toxiproxy/client"
+	"github.com/go-chi/chi/middleware"
+	"github.com/richardbolt/shrike/store"
+	log "github.com/sirupsen/logrus"
+)
+
+// Access log formats.
+const (
+	// AccessLogJSON writes a logrus JSON entry per request.
+	AccessLogJSON = "json"
+	// AccessLogCommon writes the Common Log Format followed by the Shrike fields.
+	AccessLogCommon = "common"
+	// AccessLogCombined writes the Combined Log Format followed by the Shrike fields.
+	AccessLogCombined = "combined"
+)
+
+// accessLog writes a line per proxied request.
+type accessLog struct {
+	format string
+	mu     sync.Mutex
+	out    io.Writer
+	// file is the log file, closed with the server, or nil for stdout
+	file   *os.File
+	logger *log.Logger
+	toxics *toxicNames
+}
+
+// newAccessLog writes to the file at path, or stdout for "-", in the format.
+func newAccessLog(path, format string, client *toxy.Client) (*accessLog, error) {
+	if format == "" {
+		format = AccessLogJSON
+	}
+	if format != AccessLogJSON && format != AccessLogCommon && format != AccessLogCombined {
+		return nil, fmt.Errorf("access log format must be one of %s, %s or %s", AccessLogJSON, AccessLogCommon, AccessLogCombined)
+	}
+	var out io.Writer = os.Stdout
+	var file *os.File
+	if path != "-" {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+		if err != nil {
+			return nil, err
+		}
+		out, file = f, f
+	}
+	a := &accessLog{
+		format: format,
+		out:    out,
+		file:   file,
+		toxics: &toxicNames{client: client, names: map[string][]string{}},
+	}
+	if format == AccessLogJSON {
+		a.logger = log.New()
+		a.logger.Out = out
+		a.logger.Formatter = &log.JSONFormatter{}
+	}
+	return a, nil
+}
+
+// close the log file, if there is one.
+func (a *accessLog) close() error {
+	if a.file == nil {
+		return nil
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.file.Close()
+}
+
+// accessEntry is a proxied request as written to the access log.
+type accessEntry struct {
+	req        *http.Request
+	host       string
+	status     int
+	bytes      int
+	duration   time.Duration
+	route      *store.Route
+	upstream   url.URL
+	httpToxics []string
+}
+
+// write the entry to the log.
+func (a *accessLog) write(e accessEntry) {
+	route, prefix, listen, upstream := defaultRoute, defaultRoute, "", e.upstream.String()
+	toxics := []string{}
+	if e.route != nil {
+		route, prefix, listen, upstream = e.route.Name, e.route.Prefix, e.route.Proxy.Listen, e.route.Upstream.String()
+		toxics = a.toxics.get(e.route.Proxy.Name)
+	}
+
+	if a.format == AccessLogJSON {
+		a.logger.WithFields(log.Fields{
+			"remote_addr": e.req.RemoteAddr,
+			"request_id":  middleware.GetReqID(e.req.Context()),
+			"method":      e.req.Method,
+			"uri":         e.req.RequestURI,
+			"proto":       e.req.Proto,
+			"host":        e.host,
+			"status":      e.status,
+			"bytes":       e.bytes,
+			"duration_ms": float64(e.duration) / float64(time.Millisecond),
+			"route":       route,
+			"prefix":      prefix,
+			"toxiproxy":   e.route != nil,
+			"listen":      listen,
+			"upstream":    upstream,
+			"toxics":      toxics,
+			"http_toxics": e.httpToxics,
+		}).Info("Proxied request")
+		return
+	}
+
+	remote := e.req.RemoteAddr
+	if h, _, err := net.SplitHostPort(remote); err == nil {
+		remote = h
+	}
+	bytes := "-"
+	if e.bytes > 0 {
+		bytes = fmt.Sprint(e.bytes)
+	}
+	line := fmt.Sprintf("%s - - [%s] \"%s %s %s\" %d %s",
+		remote, time.Now().Format("02/Jan/2006:15:04:05 -0700"),
+		e.req.Method, e.req.RequestURI, e.req.Proto, e.status, bytes)
+	if a.format == AccessLogCombined {
+		line += fmt.Sprintf(" %q %q", e.req.Referer(), e.req.UserAgent())
+	}
+	line += fmt.Sprintf(" route=%q toxiproxy=%t upstream=%q toxics=%q http_toxics=%q duration_ms=%.3f\n",
+		route, e.route != nil, upstream, strings.Join(toxics, ","), strings.Join(e.httpToxics, ","),
+		float64(e.duration)/float64(time.Millisecond))
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	io.WriteString(a.out, line)
+}
+
+// toxicNames holds the names of the active toxics on each proxy for the access
+// log. They're refreshed from Toxiproxy when the toxics change rather than on
+// each request, so a slow Toxiproxy doesn't hold up proxied responses.
+type toxicNames struct {
+	mu     sync.RWMutex
+	client *toxy.Client
+	names  map[string][]string
+}
+
+// refresh the names from Toxiproxy. Errors leave the last names in place.
+func (t *toxicNames) refresh() {
+	proxies, err := t.client.Proxies()
+	if err != nil {
+		log.WithField("err", err).Error("Error getting toxics for the access log")
+		return
+	}
+	t.set(proxies)
+}
+
+// set the names from the proxies.
+func (t *toxicNames) set(proxies map[string]*toxy.Proxy) {
+	names := map[string][]string{}
+	for name, p := range proxies {
+		toxics := []string{}
+		for _, toxic := range p.ActiveToxics {
+			toxics = append(toxics, toxic.Name)
+		}
+		sort.Strings(toxics)
+		names[name] = toxics
+	}
+	t.mu.Lock()
+	t.names = names
+	t.mu.Unlock()
+}
+
+// get the names of the toxics on the proxy.
+func (t *toxicNames) get(proxy string) []string {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if names, ok := t.names[proxy]; ok {
+		return names
+	}
+	return []string{}
+}
+
+// logRequest records the proxied request in the metrics and access log. The
+// host is the request's Host before it was rewritten for the upstream and
+// httpToxics are the HTTP toxics applied to the request.
+func (s *ShrikeServer) logRequest(w middleware.WrapResponseWriter, req *http.Request, host string, r *store.Route, upstream url.URL, start time.Time, httpToxics []string) {
+	d := time.Since(start)
+	s.metrics.observe(r, w.Status(), d)
+	if s.accessLog == nil {
+		return
+	}
+	s.accessLog.write(accessEntry{
+		req:        req,
+		host:       host,
+		status:     w.Status(),
+		bytes:      w.BytesWritten(),
+		duration:   d,
+		route:      r,
+		upstream:   upstream,
+		httpToxics: httpToxics,
+	})
+}
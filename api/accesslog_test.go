@@ -0,0 +1,95 @@
+package api
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	toxy "github.com/Shopify/toxiproxy/client"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/richardbolt/shrike/client"
+)
+
+var _ = Describe("Access log", func() {
+	var (
+		dir      string
+		upstream *httptest.Server
+		hung     *httptest.Server
+		release  chan struct{}
+		cfg      Config
+		s        *ShrikeServer
+	)
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "shrike")
+		Expect(err).NotTo(HaveOccurred())
+		upstream = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			w.Write([]byte("ok"))
+		}))
+		release = make(chan struct{})
+		hung = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			<-release
+		}))
+		cfg = testConfig(upstream.URL)
+		cfg.AccessLog = filepath.Join(dir, "access.log")
+		s = startServer(cfg)
+	})
+
+	AfterEach(func() {
+		s.Close()
+		close(release)
+		hung.Close()
+		upstream.Close()
+		os.RemoveAll(dir)
+	})
+
+	It("logs the toxics on the route without asking Toxiproxy for them", func() {
+		r, err := apiClient(cfg).CreateRoute(client.Route{Prefix: "/orders"})
+		Expect(err).NotTo(HaveOccurred())
+		_, err = r.AddToxic("slow", "latency", "", 1, toxy.Attributes{"latency": 1})
+		Expect(err).NotTo(HaveOccurred())
+
+		// A Toxiproxy that never answers mustn't hold up proxied requests.
+		s.accessLog.toxics.client = toxy.NewClient(strings.TrimPrefix(hung.URL, "http://"))
+		c := &http.Client{Timeout: 2 * time.Second}
+		resp, err := c.Get(fmt.Sprintf("http://127.0.0.1:%d/orders/1", cfg.Port))
+		Expect(err).NotTo(HaveOccurred())
+		resp.Body.Close()
+
+		Eventually(func() string {
+			b, _ := ioutil.ReadFile(cfg.AccessLog)
+			return string(b)
+		}).Should(ContainSubstring(`"toxics":["slow"]`))
+	})
+
+	It("logs the HTTP toxics applied to the request, not every one on the route", func() {
+		r, err := apiClient(cfg).CreateRoute(client.Route{Prefix: "/orders"})
+		Expect(err).NotTo(HaveOccurred())
+		_, err = r.AddHTTPToxic("never", HTTPToxicStatus, 0, toxy.Attributes{"status_code": 500})
+		Expect(err).NotTo(HaveOccurred())
+		_, err = r.AddHTTPToxic("always", HTTPToxicStatus, 1, toxy.Attributes{"status_code": 503})
+		Expect(err).NotTo(HaveOccurred())
+
+		resp, err := http.Get(fmt.Sprintf("http://127.0.0.1:%d/orders/1", cfg.Port))
+		Expect(err).NotTo(HaveOccurred())
+		resp.Body.Close()
+
+		Eventually(func() string {
+			b, _ := ioutil.ReadFile(cfg.AccessLog)
+			return string(b)
+		}).Should(ContainSubstring(`"http_toxics":["always"]`))
+	})
+
+	It("closes the log file with the server", func() {
+		Expect(s.Close()).To(Succeed())
+		Expect(s.accessLog.file.Close()).To(MatchError(os.ErrClosed))
+	})
+})
@@ -1,12 +1,19 @@
 package api
 
 import (
+	"context"
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"net/url"
+	"runtime/debug"
 	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/Shopify/toxiproxy"
 	toxy "github.com/Shopify/toxiproxy/client"
@@ -18,28 +25,75 @@ import (
 	"github.com/vulcand/oxy/forward"
 )
 
-// New Shrike Server.
-func New(c Config) *ShrikeServer {
-	fwd, err := forward.New()
+// New Shrike Server. The config is validated first.
+func New(c Config) (*ShrikeServer, error) {
+	if c.ToxyListenHost == "" {
+		c.ToxyListenHost = c.ToxyAddress
+	}
+	if c.ToxyPortMin == 0 && c.ToxyPortMax == 0 {
+		c.ToxyPortMin, c.ToxyPortMax = 10000, 65535
+	}
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+
+	transport := newRouteTransport()
+	// The Host header is set per request in Proxy.
+	fwd, err := forward.New(forward.RoundTripper(transport), forward.PassHostHeader(true), forward.ErrorHandler(upstreamError))
 	if err != nil {
-		log.WithFields(log.Fields{
-			"error": err,
-		}).Fatal("Failed to create a new proxy forwarder.")
+		return nil, fmt.Errorf("failed to create a new proxy forwarder: %s", err)
+	}
+
+	d, err := parseUpstream(c.UpstreamURL)
+	if err != nil {
+		return nil, err
+	}
+	hosts := map[string]url.URL{}
+	for host, upstream := range c.UpstreamHosts {
+		u, err := parseUpstream(upstream)
+		if err != nil {
+			return nil, err
+		}
+		hosts[strings.ToLower(host)] = *u
 	}
 
-	d, err := url.Parse(c.UpstreamURL)
+	t, err := listenerTLS(c)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load the listener TLS certificate: %s", err)
+	}
+	at, err := apiTLS(t, c.APIClientCA)
 	if err != nil {
-		log.Fatalf("PROXY_URL must be a valid URI: %s", err)
+		return nil, fmt.Errorf("failed to load the api client CA: %s", err)
 	}
 
-	return &ShrikeServer{
-		cfg:        c,
-		client:     toxy.NewClient(fmt.Sprintf("%s:%d", c.ToxyAddress, c.ToxyAPIPort)),
-		fwd:        fwd,
-		upstream:   d,
-		toxiproxy:  toxiproxy.NewServer(),
-		ProxyStore: store.New(*d, c.ToxyPathSeparator),
+	s := &ShrikeServer{
+		cfg:             c,
+		client:          toxy.NewClient(fmt.Sprintf("%s:%d", c.ToxyAddress, c.ToxyAPIPort)),
+		fwd:             fwd,
+		transport:       transport,
+		tls:             t,
+		apiTLS:          at,
+		upstream:        d,
+		hostUpstreams:   hosts,
+		ports:           store.NewPortAllocator(c.ToxyPortMin, c.ToxyPortMax),
+		routes:          map[string]Route{},
+		owned:           map[string]Route{},
+		routeHTTPToxics: map[string]HTTPToxics{},
+		configRoutes:    map[string]bool{},
+		expiries:        map[toxicKey]*expiry{},
+		scenarios:       map[string]*scenarioRun{},
+		ProxyStore:      store.New(*d, hosts),
+	}
+	if !c.ToxyExternal {
+		s.toxiproxy = toxiproxy.NewServer()
+	}
+	s.metrics = newMetrics(s)
+	if c.AccessLog != "" {
+		if s.accessLog, err = newAccessLog(c.AccessLog, c.AccessLogFormat, s.client); err != nil {
+			return nil, fmt.Errorf("failed to open the access log: %s", err)
+		}
 	}
+	return s, nil
 }
 
 // Config for ShrikeServer
@@ -48,14 +102,55 @@ type Config struct {
 	Port              int
 	APIPort           int
 	ToxyAddress       string
+	ToxyListenHost    string
+	ToxyExternal      bool
 	ToxyAPIPort       int
 	ToxyPathSeparator string
+	ToxyPortMin       int
+	ToxyPortMax       int
 	UpstreamURL       string
+	UpstreamHosts     map[string]string
+	TLSCert           string
+	TLSKey            string
+	TLSSelfSigned     bool
+	TLSCAOut          string
+	Persister         Persister
+	AccessLog         string
+	AccessLogFormat   string
+	APITokens         []string
+	APIReadTokens     []string
+	APIClientCA       string
+	Routes            []RouteConfig
+
+	// ToxyEphemeralPorts has proxies listen on ports picked by the OS rather
+	// than from the port range, as for tests.
+	ToxyEphemeralPorts bool
+	// DriftInterval is how often the routes are checked against the proxies in
+	// Toxiproxy after the check at startup. Zero only checks at startup.
+	DriftInterval time.Duration
+	// DriftAdoptNamed has drift repair adopt any proxy without a route that is
+	// named after a prefix, not just Shrike's own. Off by default so a shared
+	// Toxiproxy's other proxies aren't claimed.
+	DriftAdoptNamed bool
 }
 
-// Route holds information about the routing of a request
+// Route holds information about the routing of a request.
+// Routes sharing a prefix must be named and are told apart by their host,
+// methods, headers and query parameters, tried in priority order.
+// Upstream defaults to the upstream for the route's host, then the global one.
+// Prefix is the path prefix, or the exact path, glob or regular expression for
+// those match types.
 type Route struct {
-	Prefix string `json:"prefix"`
+	Name     string            `json:"name,omitempty"`
+	Prefix   string            `json:"prefix"`
+	Match    string            `json:"match,omitempty"`
+	Host     string            `json:"host,omitempty"`
+	Upstream string            `json:"upstream,omitempty"`
+	Methods  []string          `json:"methods,omitempty"`
+	Headers  map[string]string `json:"headers,omitempty"`
+	Query    map[string]string `json:"query,omitempty"`
+	Priority int               `json:"priority,omitempty"`
+	TLS      *RouteTLS         `json:"tls,omitempty"`
 }
 
 // RouteWithProxy has the proxy and path information to show clients
@@ -83,90 +178,359 @@ func (j *JSONError) Bytes() []byte {
 
 // ShrikeServer is the main Shrike server.
 // Usage:
-// server := api.New(..args)
-// server.Listen()
+//
+//	server, err := api.New(cfg)
+//	if err != nil {
+//		return err
+//	}
+//	if err := server.Start(ctx); err != nil {
+//		return err
+//	}
+//	defer server.Shutdown(ctx)
+//	return server.Wait()
+//
+// or server.Listen() to start and wait in one call.
 type ShrikeServer struct {
-	cfg        Config
-	client     *toxy.Client
-	upstream   *url.URL
-	toxiproxy  *toxiproxy.ApiServer
-	fwd        *forward.Forwarder
-	ProxyStore *store.ProxyStore
+	cfg      Config
+	client   *toxy.Client
+	upstream *url.URL
+	// default upstreams by lowercased request host
+	hostUpstreams map[string]url.URL
+	toxiproxy     *toxiproxy.ApiServer
+	fwd           *forward.Forwarder
+	transport     *routeTransport
+	tls           *tls.Config
+	apiTLS        *tls.Config
+	mu            sync.RWMutex
+	routes        map[string]Route
+	// routes by proxy name of the proxies Shrike made or loaded from its state
+	// or config file, which drift repair may adopt
+	owned map[string]Route
+	// HTTP toxics by route prefix
+	routeHTTPToxics map[string]HTTPToxics
+	persistMu       sync.Mutex
+	// changeMu is held while the routes and toxics are changed, so api calls,
+	// reloads, snapshot restores and drift repairs are applied one at a time.
+	changeMu sync.Mutex
+	ports    *store.PortAllocator
+	// prefixes last loaded from the route config file, guarded by changeMu
+	configRoutes map[string]bool
+	configStatus *ConfigStatus
+	driftStatus  *DriftStatus
+	// expiries of timed toxics. expiryMu is also held while toxics are removed.
+	expiryMu  sync.Mutex
+	expiries  map[toxicKey]*expiry
+	scenarios map[string]*scenarioRun
+	metrics   *metrics
+	accessLog *accessLog
+	// the proxy and api servers, shut down before the Toxiproxy api server
+	servers    []*http.Server
+	toxyServer *http.Server
+	// the addresses listened on, once started
+	addr, apiAddr, toxyAPIAddr string
+	errc                       chan error
+	done                       chan struct{}
+	closeOnce                  sync.Once
+	closeErr                   error
+	ProxyStore                 *store.ProxyStore
 }
 
-// Listen on all the appropriate ports
-func (s *ShrikeServer) Listen() {
-	errc := make(chan error)
-	logger := log.New()
+// Start the Toxiproxy api, the Shrike api and the proxy listening, restore the
+// routes and return, leaving them serving in the background. The context
+// bounds the startup, not how long the servers run. An error while serving is
+// returned by Wait.
+func (s *ShrikeServer) Start(ctx context.Context) error {
+	s.errc = make(chan error, 3)
+	s.done = make(chan struct{})
+
+	// Toxiproxy API Server on ToxyAPIPort (8474), unless it's external.
+	if s.toxiproxy != nil {
+		srv, addr, err := s.serve(net.JoinHostPort(s.cfg.ToxyAddress, strconv.Itoa(s.cfg.ToxyAPIPort)), s.ToxiproxyHandler(), nil)
+		if err != nil {
+			return err
+		}
+		s.toxyServer = srv
+		s.toxyAPIAddr = addr
+		if s.cfg.ToxyAPIPort == 0 {
+			// Only now is the port known to talk to it on.
+			s.client = toxy.NewClient(addr)
+			if s.accessLog != nil {
+				s.accessLog.toxics.client = s.client
+			}
+		}
+		log.WithField("addr", addr).Info("Toxiproxy API HTTP server started")
+	} else {
+		s.toxyAPIAddr = net.JoinHostPort(s.cfg.ToxyAddress, strconv.Itoa(s.cfg.ToxyAPIPort))
+		log.WithFields(log.Fields{
+			"addr":   s.toxyAPIAddr,
+			"listen": s.cfg.ToxyListenHost,
+		}).Info("Using an external Toxiproxy")
+	}
+	if err := s.loadStartRoutes(); err != nil {
+		s.Close()
+		return err
+	}
+	s.checkDrift()
+	if s.cfg.DriftInterval > 0 {
+		go s.watchDrift(s.cfg.DriftInterval)
+	}
+	if err := ctx.Err(); err != nil {
+		s.Close()
+		return err
+	}
 
-	// Toxiproxy API Server on ToxyAPIPort (8474)
-	go func() {
-		s.toxiproxy.Listen(s.cfg.ToxyAddress, strconv.Itoa(s.cfg.ToxyAPIPort))
-	}()
+	// Main proxy. Can be on the same port.
+	if !s.cfg.sharedPort() {
+		srv, addr, err := s.serve(fmt.Sprintf("%s:%d", s.cfg.Host, s.cfg.Port), s.ProxyHandler(), s.tls)
+		if err != nil {
+			s.Close()
+			return err
+		}
+		s.servers = append(s.servers, srv)
+		s.addr = addr
+		log.WithFields(log.Fields{
+			"addr": addr,
+			"tls":  s.tls != nil,
+		}).Info("Proxy HTTP server started")
+	}
 
 	// Shrike API Server on APIPort (default 8475)
-	apiMux := http.NewServeMux()
+	srv, addr, err := s.serve(fmt.Sprintf("%s:%d", s.cfg.Host, s.cfg.APIPort), s.APIHandler(), s.apiTLS)
+	if err != nil {
+		s.Close()
+		return err
+	}
+	s.servers = append(s.servers, srv)
+	s.apiAddr = addr
+	if s.cfg.sharedPort() {
+		s.addr = addr
+	}
+	log.WithFields(log.Fields{
+		"addr":  addr,
+		"tls":   s.apiTLS != nil,
+		"auth":  s.authEnabled(),
+		"proxy": s.cfg.sharedPort(),
+	}).Info("API HTTP server started")
+	return nil
+}
+
+// Addr returns the address the proxy listens on once started, with the port
+// picked when it was configured as 0.
+func (s *ShrikeServer) Addr() string {
+	return s.addr
+}
+
+// APIAddr returns the address the api listens on once started.
+func (s *ShrikeServer) APIAddr() string {
+	return s.apiAddr
+}
+
+// ToxyAPIAddr returns the address of the Toxiproxy api once started, embedded
+// or external.
+func (s *ShrikeServer) ToxyAPIAddr() string {
+	return s.toxyAPIAddr
+}
+
+// loadStartRoutes restores the persisted routes and loads those in the config.
+// changeMu is held so a reload of the route config file started meanwhile waits.
+func (s *ShrikeServer) loadStartRoutes() error {
+	s.changeMu.Lock()
+	defer s.changeMu.Unlock()
+
+	s.restore()
+	if err := s.loadRoutes(s.cfg.Routes, "config file"); err != nil {
+		return err
+	}
+	for _, rc := range s.cfg.Routes {
+		s.configRoutes[s.routeName(rc.Route)] = true
+	}
+	return nil
+}
+
+// Wait until one of the servers fails, returning why, or until the server is
+// shut down, returning nil.
+func (s *ShrikeServer) Wait() error {
+	select {
+	case err := <-s.errc:
+		return err
+	case <-s.done:
+		return nil
+	}
+}
+
+// Listen on all the appropriate ports until a server fails.
+func (s *ShrikeServer) Listen() error {
+	if err := s.Start(context.Background()); err != nil {
+		return err
+	}
+	return s.Wait()
+}
+
+// Shutdown stops accepting requests and waits for those in flight to finish,
+// then stops the scenarios and timed toxics and tears down the embedded
+// Toxiproxy's proxies and api. An external Toxiproxy's proxies are left. When
+// the context ends first the remaining connections are closed and its error
+// returned. Persisted state is left for the next start.
+func (s *ShrikeServer) Shutdown(ctx context.Context) error {
+	var err error
+	for _, srv := range s.servers {
+		if e := srv.Shutdown(ctx); e != nil && err == nil {
+			err = e
+		}
+	}
+	if e := s.Close(); e != nil && err == nil {
+		err = e
+	}
+	return err
+}
+
+// Close the servers straight away, without waiting for requests in flight, and
+// remove the embedded Toxiproxy's proxies, closing their listeners.
+func (s *ShrikeServer) Close() error {
+	s.closeOnce.Do(func() {
+		for _, srv := range s.servers {
+			srv.Close()
+		}
+		s.cancelScenarios()
+		s.cancelExpiries("")
+		if s.accessLog != nil {
+			s.accessLog.close()
+		}
+		if s.toxiproxy != nil {
+			s.closeErr = s.toxiproxy.Collection.Clear()
+		}
+		if s.toxyServer != nil {
+			s.toxyServer.Close()
+		}
+		if s.done != nil {
+			close(s.done)
+		}
+	})
+	return s.closeErr
+}
+
+// APIHandler serves the Shrike api, and the proxy too when they share a port.
+func (s *ShrikeServer) APIHandler() http.Handler {
 	r := chi.NewRouter()
 	r.Use(middleware.Heartbeat("/ping"))
 	r.Use(middleware.RequestID)
-	r.Use(middleware.Recoverer)
-	r.Use(lg.RequestLogger(logger))
-
-	r.Get("/routes", s.GetProxies)
-	r.Post("/routes", s.AddProxy)
-	r.Get("/routes/{route}", s.GetRoute)
-	r.Post("/routes/{route}", s.UpdateRoute)
-	r.Delete("/routes/{route}", s.DeleteRoute)
-	r.Get("/routes/{route}/toxics", s.GetToxics)
-	r.Post("/routes/{route}/toxics", s.CreateToxic)
-	r.Get("/routes/{route}/toxics/{toxic}", s.GetToxic)
-	r.Post("/routes/{route}/toxics/{toxic}", s.UpdateToxic)
-	r.Delete("/routes/{route}/toxics/{toxic}", s.DeleteToxic)
-	r.Post("/routes/reset", s.ResetToxics)
-	r.Delete("/routes", s.RemoveAllRoutes)
+	r.Use(recoverer)
+	r.Use(lg.RequestLogger(log.New()))
+
+	r.Group(func(r chi.Router) {
+		r.Use(s.Authenticate)
+		r.Get("/routes", s.GetProxies)
+		r.Post("/routes", s.AddProxy)
+		r.Get("/routes/{route}", s.GetRoute)
+		r.Post("/routes/{route}", s.UpdateRoute)
+		r.Delete("/routes/{route}", s.DeleteRoute)
+		r.Get("/routes/{route}/toxics", s.GetToxics)
+		r.Post("/routes/{route}/toxics", s.CreateToxic)
+		r.Get("/routes/{route}/toxics/{toxic}", s.GetToxic)
+		r.Post("/routes/{route}/toxics/{toxic}", s.UpdateToxic)
+		r.Delete("/routes/{route}/toxics/{toxic}", s.DeleteToxic)
+		r.Get("/routes/{route}/http-toxics", s.GetHTTPToxics)
+		r.Post("/routes/{route}/http-toxics", s.CreateHTTPToxic)
+		r.Get("/routes/{route}/http-toxics/{toxic}", s.GetHTTPToxic)
+		r.Post("/routes/{route}/http-toxics/{toxic}", s.UpdateHTTPToxic)
+		r.Delete("/routes/{route}/http-toxics/{toxic}", s.DeleteHTTPToxic)
+		r.Post("/routes/reset", s.ResetToxics)
+		r.Delete("/routes", s.RemoveAllRoutes)
+		r.Get("/config/status", s.GetConfigStatus)
+		r.Get("/drift", s.GetDrift)
+		r.Post("/drift", s.CheckDrift)
+		r.Get("/snapshot", s.GetSnapshot)
+		r.Put("/snapshot", s.PutSnapshot)
+		r.Get("/scenarios", s.GetScenarios)
+		r.Post("/scenarios", s.CreateScenario)
+		r.Get("/scenarios/{scenario}", s.GetScenario)
+		r.Delete("/scenarios/{scenario}", s.CancelScenario)
+		r.Get("/metrics", s.metrics.handler().ServeHTTP)
+	})
 
-	// Main proxy. Can be on the same port.
-	if s.cfg.APIPort != s.cfg.Port {
-		apiMux.Handle("/routes", r)
-
-		proxyMux := http.NewServeMux()
-		mr := chi.NewRouter()
-		// Chain HTTP Middleware
-		mr.Use(middleware.RequestID)
-		mr.Use(middleware.Recoverer)
-		mr.HandleFunc("/*", s.Proxy)
-		proxyMux.Handle("/", mr)
-
-		go func() {
-			errc <- http.ListenAndServe(fmt.Sprintf("%s:%d", s.cfg.Host, s.cfg.Port), proxyMux)
-		}()
-	} else {
+	if s.cfg.sharedPort() {
 		r.HandleFunc("/*", s.Proxy)
-		apiMux.Handle("/", r)
 	}
-	log.WithFields(log.Fields{
-		"host": s.cfg.Host,
-		"port": s.cfg.Port,
-	}).Info("Proxy HTTP server starting")
+	return r
+}
 
-	log.WithFields(log.Fields{
-		"host": s.cfg.Host,
-		"port": s.cfg.APIPort,
-	}).Info("API HTTP server starting")
+// ProxyHandler serves the proxy.
+func (s *ShrikeServer) ProxyHandler() http.Handler {
+	mr := chi.NewRouter()
+	// Chain HTTP Middleware
+	mr.Use(middleware.RequestID)
+	mr.Use(recoverer)
+	mr.HandleFunc("/*", s.Proxy)
+	return mr
+}
+
+// recoverer recovers from panics like middleware.Recoverer, logging them and
+// responding with a 500, except for http.ErrAbortHandler from abort toxics.
+// That is left to the server, which aborts the response quietly by closing the
+// connection or, for HTTP/2, resetting the stream.
+func recoverer(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		defer func() {
+			rvr := recover()
+			if rvr == nil {
+				return
+			}
+			if rvr == http.ErrAbortHandler {
+				panic(rvr)
+			}
+			log.WithFields(log.Fields{
+				"panic":      rvr,
+				"request_id": middleware.GetReqID(req.Context()),
+			}).Errorf("Panic serving request\n%s", debug.Stack())
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		}()
+		next.ServeHTTP(w, req)
+	})
+}
+
+// serve h on addr in the background, terminating TLS when it has been
+// configured, and return the server and the address listened on.
+func (s *ShrikeServer) serve(addr string, h http.Handler, t *tls.Config) (*http.Server, string, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, "", err
+	}
+	srv := &http.Server{Handler: h, TLSConfig: t}
 	go func() {
-		errc <- http.ListenAndServe(fmt.Sprintf("%s:%d", s.cfg.Host, s.cfg.APIPort), apiMux)
+		var err error
+		if t == nil {
+			err = srv.Serve(ln)
+		} else {
+			err = srv.ServeTLS(ln, "", "")
+		}
+		if err != http.ErrServerClosed {
+			s.errc <- err
+		}
 	}()
-
-	log.Fatal(<-errc)
+	return srv, ln.Addr().String(), nil
 }
 
-// Proxy requests via Toxiproxy proxies or the upstream server if no match.
+// Proxy requests via Toxiproxy proxies or the upstream server for the host if no match.
+// Requests routed by their host keep their Host header, others are sent with the upstream's.
 func (s *ShrikeServer) Proxy(w http.ResponseWriter, req *http.Request) {
+	ww := middleware.NewWrapResponseWriter(w, req.ProtoMajor)
+	w = ww
 	// Either a proxy on the Toxy or the vanilla upstream address.
-	if u, m := s.ProxyStore.Match(req.URL.Path); m {
-		req.URL = &u
-	} else {
-		req.URL = s.upstream
+	r, u, m := s.ProxyStore.Match(req)
+	host, start, fired := req.Host, time.Now(), []string{}
+	// Logged on the way out of an abort toxic's panic too.
+	defer func() { s.logRequest(ww, req, host, r, u, start, fired) }()
+	req.URL = &u
+	if m {
+		if r.Host == "" {
+			req.Host = r.Upstream.Host
+		}
+		var forward bool
+		if w, forward = applyHTTPToxics(w, s.httpToxics(r.Name), &fired); !forward {
+			return
+		}
+	} else if _, ok := s.hostUpstreams[strings.ToLower(store.RequestHost(req))]; !ok {
+		req.Host = u.Host
 	}
 	s.fwd.ServeHTTP(w, req)
 }
@@ -185,17 +549,15 @@ func (s *ShrikeServer) GetProxies(w http.ResponseWriter, req *http.Request) {
 
 	proxyEntries := s.ProxyStore.ToMap()
 	routeMap := map[string]RouteWithProxy{}
-	for k := range proxyEntries {
-		toxy := proxies[store.ProxyNameFrom(s.cfg.ToxyPathSeparator, k)]
+	for k, v := range proxyEntries {
+		toxy := proxies[k]
 		if toxy == nil {
-			log.WithField("path", k).Warn("No proxy entry found in Toxiproxy.")
+			log.WithField("path", v.Prefix).Warn("No proxy entry found in Toxiproxy.")
 			continue
 		}
-		routeMap[k] = RouteWithProxy{
-			Route: Route{
-				Prefix: k,
-			},
-			Toxy: toxy,
+		routeMap[s.routeKey(v)] = RouteWithProxy{
+			Route: s.route(k),
+			Toxy:  toxy,
 		}
 	}
 
@@ -204,6 +566,15 @@ func (s *ShrikeServer) GetProxies(w http.ResponseWriter, req *http.Request) {
 	w.Write(b)
 }
 
+// routeKey is the route's key in GET /routes: its prefix, as it always has been,
+// or its name when it was given one, as routes sharing a prefix must be.
+func (s *ShrikeServer) routeKey(r *store.Route) string {
+	if r.Name == store.ProxyNameFrom(s.cfg.ToxyPathSeparator, r.Prefix) {
+		return r.Prefix
+	}
+	return r.Name
+}
+
 // AddProxy cfg to Toxiproxy.
 func (s *ShrikeServer) AddProxy(w http.ResponseWriter, req *http.Request) {
 	// Decode payload into a Route
@@ -218,25 +589,34 @@ func (s *ShrikeServer) AddProxy(w http.ResponseWriter, req *http.Request) {
 		})
 		return
 	}
-	proxyName := store.ProxyNameFrom(s.cfg.ToxyPathSeparator, doc.Prefix)
-	proxy, err := s.client.CreateProxy(
-		proxyName,
-		fmt.Sprintf("%s:%d", s.cfg.ToxyAddress, store.NumFrom(proxyName)),
-		s.upstream.Host,
-	)
+	if err := doc.Validate(); err != nil {
+		log.WithField("err", err).Info("Invalid route")
+		RespondWithError(w, http.StatusBadRequest, JSONError{
+			Status:  "Bad Request",
+			Message: "Route is not valid: " + err.Error(),
+		})
+		return
+	}
+	s.changeMu.Lock()
+	defer s.changeMu.Unlock()
+	proxy, err := s.addRoute(*doc)
+	if err == store.ErrPortsExhausted {
+		log.WithField("Route", doc.Prefix).Error("No Toxiproxy ports left for a proxy")
+		RespondWithError(w, http.StatusConflict, JSONError{
+			Status:  "Conflict",
+			Message: "No free Toxiproxy ports are left in the port range.",
+		})
+		return
+	}
 	if err != nil {
-		proxy, err = s.client.Proxy(proxyName)
-		if err != nil {
-			log.WithField("err", err).Error("Error creating/getting a proxy")
-			RespondWithError(w, http.StatusInternalServerError, JSONError{
-				Status:  "Server Error",
-				Message: "Could not create the path entry.",
-			})
-			return
-		}
+		log.WithField("err", err).Error("Error creating/getting a proxy")
+		RespondWithError(w, http.StatusInternalServerError, JSONError{
+			Status:  "Server Error",
+			Message: "Could not create the path entry.",
+		})
+		return
 	}
-
-	s.ProxyStore.Add(proxy)
+	s.changed()
 
 	w.Header().Set("Content-Type", "application/json")
 	b, _ := json.Marshal(proxy)
@@ -245,7 +625,7 @@ func (s *ShrikeServer) AddProxy(w http.ResponseWriter, req *http.Request) {
 
 // GetRoute gets proxies from Toxiproxy and maps with the routes we match from.
 func (s *ShrikeServer) GetRoute(w http.ResponseWriter, req *http.Request) {
-	route := chi.URLParam(req, "route")
+	route := s.routeRef(req)
 	if route == "" {
 		log.WithField("Route", route).Info("Route must be the name of one of the proxy paths.")
 		RespondWithError(w, http.StatusBadRequest, JSONError{
@@ -268,7 +648,7 @@ func (s *ShrikeServer) GetRoute(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	if p := s.ProxyStore.Get(store.PathNameFrom(s.cfg.ToxyPathSeparator, route)); p == nil {
+	if p := s.ProxyStore.Get(route); p == nil {
 		log.WithFields(log.Fields{
 			"Route": route,
 			"err":   err,
@@ -281,10 +661,8 @@ func (s *ShrikeServer) GetRoute(w http.ResponseWriter, req *http.Request) {
 	}
 
 	b, _ := json.Marshal(RouteWithProxy{
-		Route: Route{
-			Prefix: store.PathNameFrom(s.cfg.ToxyPathSeparator, route),
-		},
-		Toxy: toxy,
+		Route: s.route(route),
+		Toxy:  toxy,
 	})
 	w.Header().Set("Content-Type", "application/json")
 	w.Write(b)
@@ -304,7 +682,7 @@ func (s *ShrikeServer) UpdateRoute(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	route := chi.URLParam(req, "route")
+	route := s.routeRef(req)
 	if route == "" {
 		log.WithField("Route", route).Info("Route must be the name of one of the proxy paths.")
 		RespondWithError(w, http.StatusBadRequest, JSONError{
@@ -314,6 +692,8 @@ func (s *ShrikeServer) UpdateRoute(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	s.changeMu.Lock()
+	defer s.changeMu.Unlock()
 	proxy, err := s.client.Proxy(route)
 	if err != nil {
 		log.WithFields(log.Fields{
@@ -332,6 +712,7 @@ func (s *ShrikeServer) UpdateRoute(w http.ResponseWriter, req *http.Request) {
 	} else if !doc.Enabled {
 		proxy.Disable()
 	}
+	s.changed()
 
 	b, _ := json.Marshal(proxy)
 	w.Header().Set("Content-Type", "application/json")
@@ -340,7 +721,7 @@ func (s *ShrikeServer) UpdateRoute(w http.ResponseWriter, req *http.Request) {
 
 // DeleteRoute removes a route from the proxy
 func (s *ShrikeServer) DeleteRoute(w http.ResponseWriter, req *http.Request) {
-	route := chi.URLParam(req, "route")
+	route := s.routeRef(req)
 	if route == "" {
 		log.WithField("Route", route).Info("Route must be the name of one of the proxy paths.")
 		RespondWithError(w, http.StatusBadRequest, JSONError{
@@ -350,6 +731,8 @@ func (s *ShrikeServer) DeleteRoute(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	s.changeMu.Lock()
+	defer s.changeMu.Unlock()
 	proxy, err := s.client.Proxy(route)
 	if err != nil {
 		log.WithFields(log.Fields{
@@ -375,7 +758,8 @@ func (s *ShrikeServer) DeleteRoute(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	s.ProxyStore.Delete(proxy)
+	s.removeRoute(proxy)
+	s.changed()
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusNoContent)
@@ -383,7 +767,7 @@ func (s *ShrikeServer) DeleteRoute(w http.ResponseWriter, req *http.Request) {
 
 // GetToxics for the given route.
 func (s *ShrikeServer) GetToxics(w http.ResponseWriter, req *http.Request) {
-	route := chi.URLParam(req, "route")
+	route := s.routeRef(req)
 	if route == "" {
 		log.WithField("Route", route).Info("Route must be the name of one of the proxy paths.")
 		RespondWithError(w, http.StatusBadRequest, JSONError{
@@ -406,7 +790,11 @@ func (s *ShrikeServer) GetToxics(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	t, err := proxy.Toxics()
+	toxics, err := proxy.Toxics()
+	t := []TimedToxic{}
+	for _, toxic := range toxics {
+		t = append(t, s.timedToxic(route, toxic))
+	}
 	b, _ := json.Marshal(t)
 	w.Header().Set("Content-Type", "application/json")
 	w.Write(b)
@@ -416,7 +804,7 @@ func (s *ShrikeServer) GetToxics(w http.ResponseWriter, req *http.Request) {
 func (s *ShrikeServer) CreateToxic(w http.ResponseWriter, req *http.Request) {
 	defer req.Body.Close()
 	body, _ := ioutil.ReadAll(req.Body)
-	doc := &toxy.Toxic{}
+	doc := &TimedToxic{}
 	if err := json.Unmarshal(body, &doc); err != nil || doc.Type == "" {
 		log.Errorf("Error unmarshaling body %s", err)
 		RespondWithError(w, http.StatusBadRequest, JSONError{
@@ -425,8 +813,17 @@ func (s *ShrikeServer) CreateToxic(w http.ResponseWriter, req *http.Request) {
 		})
 		return
 	}
+	expiresAt, err := doc.expiresAt(time.Now())
+	if err != nil {
+		log.WithField("err", err).Info("Invalid toxic expiry")
+		RespondWithError(w, http.StatusBadRequest, JSONError{
+			Status:  "Bad Request",
+			Message: "Toxic expiry is not valid: " + err.Error(),
+		})
+		return
+	}
 
-	route := chi.URLParam(req, "route")
+	route := s.routeRef(req)
 	if route == "" {
 		log.WithField("Route", route).Info("Route must be the name of one of the proxy paths.")
 		RespondWithError(w, http.StatusBadRequest, JSONError{
@@ -436,6 +833,8 @@ func (s *ShrikeServer) CreateToxic(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	s.changeMu.Lock()
+	defer s.changeMu.Unlock()
 	proxy, err := s.client.Proxy(route)
 	if err != nil {
 		log.WithFields(log.Fields{
@@ -449,7 +848,7 @@ func (s *ShrikeServer) CreateToxic(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	t, err := proxy.AddToxic(doc.Name, doc.Type, doc.Stream, doc.Toxicity, doc.Attributes)
+	t, err := s.addToxic(proxy, doc.Toxic, expiresAt)
 	if err != nil {
 		log.WithFields(log.Fields{
 			"Route": route,
@@ -461,16 +860,17 @@ func (s *ShrikeServer) CreateToxic(w http.ResponseWriter, req *http.Request) {
 		})
 		return
 	}
+	s.changed()
 
-	b, _ := json.Marshal(t)
+	b, _ := json.Marshal(s.timedToxic(route, *t))
 	w.Header().Set("Content-Type", "application/json")
 	w.Write(b)
 }
 
 // GetToxic from the proxy
 func (s *ShrikeServer) GetToxic(w http.ResponseWriter, req *http.Request) {
-	route := chi.URLParam(req, "route")
-	toxic := chi.URLParam(req, "toxic")
+	route := s.routeRef(req)
+	toxic := pathParam(req, "toxic")
 	if route == "" {
 		log.WithFields(log.Fields{
 			"Route": route,
@@ -530,7 +930,7 @@ func (s *ShrikeServer) GetToxic(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	b, _ := json.Marshal(t)
+	b, _ := json.Marshal(s.timedToxic(route, *t))
 	w.Header().Set("Content-Type", "application/json")
 	w.Write(b)
 }
@@ -539,7 +939,10 @@ func (s *ShrikeServer) GetToxic(w http.ResponseWriter, req *http.Request) {
 func (s *ShrikeServer) UpdateToxic(w http.ResponseWriter, req *http.Request) {
 	defer req.Body.Close()
 	body, _ := ioutil.ReadAll(req.Body)
-	doc := &toxy.Toxic{}
+	doc := &struct {
+		Toxicity   *float32        `json:"toxicity"`
+		Attributes toxy.Attributes `json:"attributes"`
+	}{}
 	if err := json.Unmarshal(body, &doc); err != nil {
 		log.Errorf("Error unmarshaling body %s", err)
 		RespondWithError(w, http.StatusBadRequest, JSONError{
@@ -549,8 +952,8 @@ func (s *ShrikeServer) UpdateToxic(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	route := chi.URLParam(req, "route")
-	toxic := chi.URLParam(req, "toxic")
+	route := s.routeRef(req)
+	toxic := pathParam(req, "toxic")
 	if route == "" {
 		log.WithFields(log.Fields{
 			"Route": route,
@@ -575,6 +978,8 @@ func (s *ShrikeServer) UpdateToxic(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	s.changeMu.Lock()
+	defer s.changeMu.Unlock()
 	proxy, err := s.client.Proxy(route)
 	if err != nil {
 		log.WithFields(log.Fields{
@@ -589,7 +994,17 @@ func (s *ShrikeServer) UpdateToxic(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	t, err := proxy.UpdateToxic(toxic, doc.Toxicity, doc.Attributes)
+	// As with Toxiproxy's own api, the toxicity is kept when it isn't given.
+	toxicity := float32(1)
+	for _, t := range proxy.ActiveToxics {
+		if t.Name == toxic {
+			toxicity = t.Toxicity
+		}
+	}
+	if doc.Toxicity != nil {
+		toxicity = *doc.Toxicity
+	}
+	t, err := proxy.UpdateToxic(toxic, toxicity, doc.Attributes)
 	if err != nil {
 		log.WithFields(log.Fields{
 			"Route": route,
@@ -602,6 +1017,7 @@ func (s *ShrikeServer) UpdateToxic(w http.ResponseWriter, req *http.Request) {
 		})
 		return
 	}
+	s.changed()
 
 	b, _ := json.Marshal(t)
 	w.Header().Set("Content-Type", "application/json")
@@ -610,8 +1026,8 @@ func (s *ShrikeServer) UpdateToxic(w http.ResponseWriter, req *http.Request) {
 
 // DeleteToxic from the proxy
 func (s *ShrikeServer) DeleteToxic(w http.ResponseWriter, req *http.Request) {
-	route := chi.URLParam(req, "route")
-	toxic := chi.URLParam(req, "toxic")
+	route := s.routeRef(req)
+	toxic := pathParam(req, "toxic")
 	if route == "" {
 		log.WithFields(log.Fields{
 			"Route": route,
@@ -636,6 +1052,8 @@ func (s *ShrikeServer) DeleteToxic(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	s.changeMu.Lock()
+	defer s.changeMu.Unlock()
 	proxy, err := s.client.Proxy(route)
 	if err != nil {
 		log.WithFields(log.Fields{
@@ -650,7 +1068,7 @@ func (s *ShrikeServer) DeleteToxic(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	err = proxy.RemoveToxic(toxic)
+	err = s.removeToxic(proxy, toxic)
 	if err != nil {
 		log.WithFields(log.Fields{
 			"Route": route,
@@ -663,6 +1081,7 @@ func (s *ShrikeServer) DeleteToxic(w http.ResponseWriter, req *http.Request) {
 		})
 		return
 	}
+	s.changed()
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusNoContent)
@@ -670,16 +1089,32 @@ func (s *ShrikeServer) DeleteToxic(w http.ResponseWriter, req *http.Request) {
 
 // ResetToxics removes toxics from all Routes and reenables all Route proxies
 func (s *ShrikeServer) ResetToxics(w http.ResponseWriter, req *http.Request) {
-	_ = s.client.ResetState()
+	s.changeMu.Lock()
+	defer s.changeMu.Unlock()
+	_ = s.resetToxics()
+	s.changed()
 	w.WriteHeader(http.StatusNoContent)
 }
 
+// resetToxics removes every toxic and HTTP toxic and re-enables every proxy.
+func (s *ShrikeServer) resetToxics() error {
+	err := s.client.ResetState()
+	s.cancelExpiries("")
+	s.mu.Lock()
+	s.routeHTTPToxics = map[string]HTTPToxics{}
+	s.mu.Unlock()
+	return err
+}
+
 // RemoveAllRoutes removes all routes. A hard reset on everything.
 func (s *ShrikeServer) RemoveAllRoutes(w http.ResponseWriter, req *http.Request) {
+	s.changeMu.Lock()
+	defer s.changeMu.Unlock()
 	for _, v := range s.ProxyStore.ToMap() {
-		s.ProxyStore.Delete(v)
-		v.Delete()
+		s.removeRoute(v.Proxy)
+		v.Proxy.Delete()
 	}
+	s.changed()
 
 	w.WriteHeader(http.StatusNoContent)
 }
@@ -0,0 +1,76 @@
+package api
+
+import (
+	"context"
+	"crypto/tls"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/richardbolt/shrike/client"
+	log "github.com/sirupsen/logrus"
+)
+
+func TestAPI(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "API Suite")
+}
+
+// testConfig is a config for a server with an embedded Toxiproxy on free
+// loopback ports, proxying to upstream.
+func testConfig(upstream string) Config {
+	ports := freePorts(3)
+	return Config{
+		Host:               "127.0.0.1",
+		Port:               ports[0],
+		APIPort:            ports[1],
+		ToxyAddress:        "127.0.0.1",
+		ToxyAPIPort:        ports[2],
+		ToxyPathSeparator:  "__",
+		UpstreamURL:        upstream,
+		ToxyEphemeralPorts: true,
+	}
+}
+
+// startServer starts a server with the config. The caller closes it.
+func startServer(c Config) *ShrikeServer {
+	s, err := New(c)
+	Expect(err).NotTo(HaveOccurred())
+	Expect(s.Start(context.Background())).To(Succeed())
+	return s
+}
+
+// apiClient for the api of a server started with the config.
+func apiClient(c Config) *client.Client {
+	addr := net.JoinHostPort(c.Host, strconv.Itoa(c.APIPort))
+	if !c.TLSSelfSigned {
+		return client.NewClient(addr)
+	}
+	cl := client.NewClient("https://" + addr)
+	cl.HTTPClient = tlsClient()
+	return cl
+}
+
+// tlsClient trusts any certificate and speaks HTTP/2 when it can.
+func tlsClient() *http.Client {
+	return &http.Client{Transport: &http.Transport{
+		TLSClientConfig:   &tls.Config{InsecureSkipVerify: true},
+		ForceAttemptHTTP2: true,
+	}}
+}
+
+func freePorts(n int) []int {
+	ports := []int{}
+	for i := 0; i < n; i++ {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).NotTo(HaveOccurred())
+		defer ln.Close()
+		ports = append(ports, ln.Addr().(*net.TCPAddr).Port)
+	}
+	return ports
+}
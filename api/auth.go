@@ -0,0 +1,107 @@
+package api
+
+import (
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// apiTLS returns the listener TLS config for the api, asking for client
+// certificates signed by the CA in caFile when one is given. Certificates are
+// only asked for, not required, so proxy traffic sharing the port isn't refused.
+func apiTLS(t *tls.Config, caFile string) (*tls.Config, error) {
+	if caFile == "" {
+		return t, nil
+	}
+	if t == nil {
+		return nil, fmt.Errorf("a client CA needs the listener TLS certificate to be set")
+	}
+	pem, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in %s", caFile)
+	}
+	c := t.Clone()
+	c.ClientCAs = pool
+	c.ClientAuth = tls.VerifyClientCertIfGiven
+	return c, nil
+}
+
+// authEnabled reports whether the api needs a token or client certificate.
+func (s *ShrikeServer) authEnabled() bool {
+	return len(s.cfg.APITokens) > 0 || len(s.cfg.APIReadTokens) > 0 || s.cfg.APIClientCA != ""
+}
+
+// Authenticate is middleware requiring api requests to carry a bearer token or
+// a client certificate signed by the client CA. Read-only tokens can only make
+// GET and HEAD requests. Nothing is required when no tokens or CA are set.
+func (s *ShrikeServer) Authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if !s.authEnabled() {
+			next.ServeHTTP(w, req)
+			return
+		}
+		if req.TLS != nil && len(req.TLS.VerifiedChains) > 0 {
+			next.ServeHTTP(w, req)
+			return
+		}
+
+		token := bearerToken(req)
+		if token != "" && hasToken(s.cfg.APITokens, token) {
+			next.ServeHTTP(w, req)
+			return
+		}
+		if token != "" && hasToken(s.cfg.APIReadTokens, token) {
+			if req.Method == http.MethodGet || req.Method == http.MethodHead {
+				next.ServeHTTP(w, req)
+				return
+			}
+			log.WithFields(log.Fields{
+				"method": req.Method,
+				"path":   req.URL.Path,
+			}).Info("Read-only token used for a change")
+			RespondWithError(w, http.StatusForbidden, JSONError{
+				Status:  "Forbidden",
+				Message: "The token is read-only.",
+			})
+			return
+		}
+
+		log.WithFields(log.Fields{
+			"method": req.Method,
+			"path":   req.URL.Path,
+		}).Info("Unauthenticated api request")
+		w.Header().Set("WWW-Authenticate", `Bearer realm="shrike"`)
+		RespondWithError(w, http.StatusUnauthorized, JSONError{
+			Status:  "Unauthorized",
+			Message: "A valid bearer token or client certificate is required.",
+		})
+	})
+}
+
+// bearerToken from the request's Authorization header.
+func bearerToken(req *http.Request) string {
+	h := req.Header.Get("Authorization")
+	if len(h) < 7 || !strings.EqualFold(h[:7], "Bearer ") {
+		return ""
+	}
+	return strings.TrimSpace(h[7:])
+}
+
+// hasToken compares the token to each of tokens in constant time.
+func hasToken(tokens []string, token string) bool {
+	found := 0
+	for _, t := range tokens {
+		found |= subtle.ConstantTimeCompare([]byte(t), []byte(token))
+	}
+	return found == 1
+}
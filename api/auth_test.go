@@ -0,0 +1,167 @@
+package api
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// clientCert generates a CA and a client certificate signed by it, returning
+// the CA PEM encoded.
+func clientCert() ([]byte, tls.Certificate) {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	Expect(err).NotTo(HaveOccurred())
+	caTmpl := &x509.Certificate{
+		SerialNumber:          serialNumber(),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTmpl, caTmpl, &caKey.PublicKey, caKey)
+	Expect(err).NotTo(HaveOccurred())
+	ca, err := x509.ParseCertificate(caDER)
+	Expect(err).NotTo(HaveOccurred())
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	Expect(err).NotTo(HaveOccurred())
+	tmpl := &x509.Certificate{
+		SerialNumber: serialNumber(),
+		Subject:      pkix.Name{CommonName: "client"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca, &key.PublicKey, caKey)
+	Expect(err).NotTo(HaveOccurred())
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER}),
+		tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+var _ = Describe("Authenticate", func() {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {})
+
+	request := func(cfg Config, method, auth string) *httptest.ResponseRecorder {
+		s := &ShrikeServer{cfg: cfg}
+		req := httptest.NewRequest(method, "/routes", nil)
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		w := httptest.NewRecorder()
+		s.Authenticate(ok).ServeHTTP(w, req)
+		return w
+	}
+
+	tokens := Config{APITokens: []string{"write"}, APIReadTokens: []string{"read"}}
+
+	It("lets everything through when no tokens or CA are set", func() {
+		Expect(request(Config{}, "POST", "").Code).To(Equal(http.StatusOK))
+	})
+
+	It("lets through requests with a token", func() {
+		Expect(request(tokens, "POST", "Bearer write").Code).To(Equal(http.StatusOK))
+		Expect(request(tokens, "DELETE", "bearer write").Code).To(Equal(http.StatusOK))
+	})
+
+	It("only lets read tokens get", func() {
+		Expect(request(tokens, "GET", "Bearer read").Code).To(Equal(http.StatusOK))
+		Expect(request(tokens, "HEAD", "Bearer read").Code).To(Equal(http.StatusOK))
+		Expect(request(tokens, "POST", "Bearer read").Code).To(Equal(http.StatusForbidden))
+	})
+
+	It("refuses requests without a valid token", func() {
+		for _, auth := range []string{"", "Bearer wrong", "Basic d3JpdGU=", "write", "Bearer "} {
+			w := request(tokens, "GET", auth)
+			Expect(w.Code).To(Equal(http.StatusUnauthorized), auth)
+			Expect(w.Header().Get("WWW-Authenticate")).To(Equal(`Bearer realm="shrike"`))
+		}
+	})
+
+	Context("with a client CA", func() {
+		var (
+			dir    string
+			cfg    Config
+			s      *ShrikeServer
+			client func(certs ...tls.Certificate) *http.Client
+		)
+
+		BeforeEach(func() {
+			var err error
+			dir, err = ioutil.TempDir("", "shrike-auth")
+			Expect(err).NotTo(HaveOccurred())
+			client = func(certs ...tls.Certificate) *http.Client {
+				return &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+					InsecureSkipVerify: true,
+					Certificates:       certs,
+				}}}
+			}
+		})
+
+		AfterEach(func() {
+			os.RemoveAll(dir)
+		})
+
+		start := func(ca []byte) {
+			Expect(ioutil.WriteFile(filepath.Join(dir, "ca.pem"), ca, 0600)).To(Succeed())
+			cfg = testConfig("http://127.0.0.1:1")
+			cfg.TLSSelfSigned = true
+			cfg.APIClientCA = filepath.Join(dir, "ca.pem")
+			s = startServer(cfg)
+		}
+
+		get := func(c *http.Client) (int, error) {
+			resp, err := c.Get(fmt.Sprintf("https://127.0.0.1:%d/routes", cfg.APIPort))
+			if err != nil {
+				return 0, err
+			}
+			resp.Body.Close()
+			return resp.StatusCode, nil
+		}
+
+		It("lets through requests with a certificate signed by the CA", func() {
+			ca, cert := clientCert()
+			start(ca)
+			defer s.Close()
+
+			Expect(get(client(cert))).To(Equal(http.StatusOK))
+			Expect(get(client())).To(Equal(http.StatusUnauthorized))
+		})
+
+		It("refuses certificates signed by another CA", func() {
+			ca, _ := clientCert()
+			_, other := clientCert()
+			start(ca)
+			defer s.Close()
+
+			_, err := get(client(other))
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("needs a listener certificate and a CA file with certificates", func() {
+			ca, _ := clientCert()
+			Expect(ioutil.WriteFile(filepath.Join(dir, "ca.pem"), ca, 0600)).To(Succeed())
+			_, err := apiTLS(nil, filepath.Join(dir, "ca.pem"))
+			Expect(err).To(HaveOccurred())
+			Expect(ioutil.WriteFile(filepath.Join(dir, "empty.pem"), []byte("nothing"), 0600)).To(Succeed())
+			_, err = apiTLS(&tls.Config{}, filepath.Join(dir, "empty.pem"))
+			Expect(err).To(MatchError(ContainSubstring("no certificates found")))
+		})
+	})
+})
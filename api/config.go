@@ -0,0 +1,75 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/richardbolt/shrike/store"
+)
+
+// Validate the config, returning the first problem found. New validates the
+// config after filling in the defaults for the Toxiproxy listen host and port
+// range. A port of 0 is picked by the OS when the server starts.
+func (c Config) Validate() error {
+	ports := []struct {
+		name string
+		port int
+	}{
+		{"port", c.Port},
+		{"api port", c.APIPort},
+		{"Toxiproxy API port", c.ToxyAPIPort},
+	}
+	for _, p := range ports {
+		if p.port < 0 || p.port > 65535 {
+			return fmt.Errorf("%s %d must be between 0 and 65535", p.name, p.port)
+		}
+	}
+	if c.ToxyExternal && c.ToxyAPIPort == 0 {
+		return errors.New("an external Toxiproxy API port must be set")
+	}
+	if !c.ToxyExternal && c.ToxyAPIPort != 0 && (c.ToxyAPIPort == c.Port || c.ToxyAPIPort == c.APIPort) {
+		return fmt.Errorf("Toxiproxy API port %d is already the port or api port", c.ToxyAPIPort)
+	}
+	if c.ToxyPortMin < 1 || c.ToxyPortMax > 65535 || c.ToxyPortMin > c.ToxyPortMax {
+		return fmt.Errorf("Toxiproxy port range %d-%d is not valid", c.ToxyPortMin, c.ToxyPortMax)
+	}
+	if c.ToxyAddress == "" {
+		return errors.New("Toxiproxy address must be set")
+	}
+	if c.ToxyPathSeparator == "" {
+		return errors.New("Toxiproxy path separator must be set")
+	}
+	// Route names escape the separator as ~ and two hex digits.
+	if strings.ContainsAny(c.ToxyPathSeparator, "/"+store.Escape+"0123456789abcdefABCDEF") {
+		return fmt.Errorf("Toxiproxy path separator %q must not contain /, %s or hex digits", c.ToxyPathSeparator, store.Escape)
+	}
+
+	if _, err := parseUpstream(c.UpstreamURL); err != nil {
+		return err
+	}
+	for host, upstream := range c.UpstreamHosts {
+		if _, err := parseUpstream(upstream); err != nil {
+			return fmt.Errorf("upstream for host %s is not valid: %s", host, err)
+		}
+	}
+
+	if (c.TLSCert == "") != (c.TLSKey == "") {
+		return errors.New("both a TLS certificate and key are required")
+	}
+	if c.APIClientCA != "" && c.TLSCert == "" && !c.TLSSelfSigned {
+		return errors.New("a client CA needs the listener TLS certificate to be set")
+	}
+	switch c.AccessLogFormat {
+	case "", AccessLogJSON, AccessLogCommon, AccessLogCombined:
+	default:
+		return fmt.Errorf("access log format must be one of %s, %s or %s", AccessLogJSON, AccessLogCommon, AccessLogCombined)
+	}
+	return nil
+}
+
+// sharedPort reports whether the proxy is served on the api port. Two ports of
+// 0 are each picked by the OS, so aren't shared.
+func (c Config) sharedPort() bool {
+	return c.Port != 0 && c.Port == c.APIPort
+}
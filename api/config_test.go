@@ -0,0 +1,72 @@
+package api
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Config", func() {
+	valid := func() Config {
+		return Config{
+			Host:              "0.0.0.0",
+			Port:              8080,
+			APIPort:           8475,
+			ToxyAddress:       "127.0.0.1",
+			ToxyAPIPort:       8474,
+			ToxyPathSeparator: "__",
+			ToxyPortMin:       10000,
+			ToxyPortMax:       65535,
+			UpstreamURL:       "http://localhost",
+		}
+	}
+
+	It("accepts a valid config", func() {
+		Expect(valid().Validate()).To(Succeed())
+		c := valid()
+		c.ToxyExternal = true
+		c.ToxyAPIPort = c.Port
+		c.ToxyPathSeparator = "-"
+		c.TLSSelfSigned = true
+		c.APIClientCA = "ca.pem"
+		c.AccessLogFormat = AccessLogCommon
+		Expect(c.Validate()).To(Succeed())
+	})
+
+	It("accepts ports of 0 for the OS to pick", func() {
+		c := valid()
+		c.Port, c.APIPort, c.ToxyAPIPort = 0, 0, 0
+		Expect(c.Validate()).To(Succeed())
+		Expect(c.sharedPort()).To(BeFalse())
+	})
+
+	It("rejects each problem", func() {
+		for _, c := range []struct {
+			problem string
+			change  func(*Config)
+		}{
+			{"port -1 must be between 0 and 65535", func(c *Config) { c.Port = -1 }},
+			{"api port 65536 must be between 0 and 65535", func(c *Config) { c.APIPort = 65536 }},
+			{"Toxiproxy API port -1 must be between 0 and 65535", func(c *Config) { c.ToxyAPIPort = -1 }},
+			{"external Toxiproxy API port must be set", func(c *Config) { c.ToxyExternal = true; c.ToxyAPIPort = 0 }},
+			{"is already the port or api port", func(c *Config) { c.ToxyAPIPort = c.APIPort }},
+			{"port range 20000-10000 is not valid", func(c *Config) { c.ToxyPortMin = 20000; c.ToxyPortMax = 10000 }},
+			{"port range 0-65535 is not valid", func(c *Config) { c.ToxyPortMin = 0 }},
+			{"Toxiproxy address must be set", func(c *Config) { c.ToxyAddress = "" }},
+			{"path separator must be set", func(c *Config) { c.ToxyPathSeparator = "" }},
+			{`path separator "/" must not contain`, func(c *Config) { c.ToxyPathSeparator = "/" }},
+			{`path separator "~" must not contain`, func(c *Config) { c.ToxyPathSeparator = "~" }},
+			{`path separator "_a_" must not contain`, func(c *Config) { c.ToxyPathSeparator = "_a_" }},
+			{"must be an http or https URL", func(c *Config) { c.UpstreamURL = "ftp://localhost" }},
+			{"upstream for host api.example.com is not valid", func(c *Config) {
+				c.UpstreamHosts = map[string]string{"api.example.com": "::"}
+			}},
+			{"both a TLS certificate and key are required", func(c *Config) { c.TLSCert = "cert.pem" }},
+			{"a client CA needs the listener TLS certificate", func(c *Config) { c.APIClientCA = "ca.pem" }},
+			{"access log format must be one of", func(c *Config) { c.AccessLogFormat = "xml" }},
+		} {
+			config := valid()
+			c.change(&config)
+			Expect(config.Validate()).To(MatchError(ContainSubstring(c.problem)), c.problem)
+		}
+	})
+})
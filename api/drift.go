@@ -0,0 +1,201 @@
+package api
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	toxy "github.com/Shopify/toxiproxy/client"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/richardbolt/shrike/store"
+)
+
+// DriftStatus is the result of the last check of the routes against the
+// proxies in Toxiproxy.
+type DriftStatus struct {
+	Time time.Time `json:"time"`
+	// Adopted are Shrike's proxies that had no route, whose routes were added
+	// again, and with DriftAdoptNamed those named after a prefix.
+	Adopted []string `json:"adopted"`
+	// Removed are routes whose proxy was missing from Toxiproxy.
+	Removed []string `json:"removed"`
+	// Refreshed are routes whose proxy had moved to another listen address.
+	Refreshed []string `json:"refreshed"`
+	// Ignored are the other proxies, which are left alone.
+	Ignored []string `json:"ignored"`
+	Error   string   `json:"error,omitempty"`
+}
+
+func (d DriftStatus) drifted() bool {
+	return len(d.Adopted) > 0 || len(d.Removed) > 0 || len(d.Refreshed) > 0
+}
+
+// checkDrift brings the routes in line with the proxies in Toxiproxy: Shrike's
+// proxies without a route are adopted, as are those named after a prefix when
+// DriftAdoptNamed is set, routes without a proxy are removed and routes whose
+// proxy has moved are pointed at it again.
+func (s *ShrikeServer) checkDrift() DriftStatus {
+	s.changeMu.Lock()
+	defer s.changeMu.Unlock()
+
+	status := DriftStatus{
+		Time:      time.Now(),
+		Adopted:   []string{},
+		Removed:   []string{},
+		Refreshed: []string{},
+		Ignored:   []string{},
+	}
+	proxies, err := s.client.Proxies()
+	if err != nil {
+		status.Error = err.Error()
+		log.WithField("err", err).Error("Error getting proxies to check for drift")
+		s.setDriftStatus(status)
+		return status
+	}
+	if s.accessLog != nil {
+		s.accessLog.toxics.set(proxies)
+	}
+	routes := s.ProxyStore.ToMap()
+
+	for name, r := range routes {
+		proxy, ok := proxies[name]
+		switch {
+		case !ok:
+			log.WithFields(log.Fields{
+				"route":  name,
+				"prefix": r.Prefix,
+			}).Warn("Removing a route whose proxy is missing from Toxiproxy")
+			s.removeRoute(r.Proxy)
+			status.Removed = append(status.Removed, name)
+		case s.dialAddr(proxy.Listen) != s.dialAddr(r.Proxy.Listen):
+			log.WithFields(log.Fields{
+				"route":  name,
+				"from":   r.Proxy.Listen,
+				"listen": proxy.Listen,
+			}).Warn("Refreshing a route whose proxy has moved")
+			s.transport.Remove(s.dialAddr(r.Proxy.Listen))
+			if _, err := s.addRoute(s.route(name)); err != nil {
+				log.WithFields(log.Fields{
+					"route": name,
+					"err":   err,
+				}).Error("Error refreshing route")
+				continue
+			}
+			status.Refreshed = append(status.Refreshed, name)
+		}
+	}
+
+	for name, proxy := range proxies {
+		if _, ok := routes[name]; ok {
+			continue
+		}
+		r, ok := s.ownedRoute(name)
+		if !ok && s.cfg.DriftAdoptNamed {
+			r, ok = s.namedRoute(proxy)
+		}
+		if !ok {
+			status.Ignored = append(status.Ignored, name)
+			continue
+		}
+		log.WithFields(log.Fields{
+			"proxy":  name,
+			"prefix": r.Prefix,
+		}).Warn("Adopting a Toxiproxy proxy without a route")
+		if _, err := s.addRoute(r); err != nil {
+			log.WithFields(log.Fields{
+				"proxy": name,
+				"err":   err,
+			}).Error("Error adopting proxy")
+			continue
+		}
+		status.Adopted = append(status.Adopted, name)
+	}
+
+	sort.Strings(status.Adopted)
+	sort.Strings(status.Removed)
+	sort.Strings(status.Refreshed)
+	sort.Strings(status.Ignored)
+	if status.drifted() {
+		s.changed()
+		log.WithFields(log.Fields{
+			"adopted":   len(status.Adopted),
+			"removed":   len(status.Removed),
+			"refreshed": len(status.Refreshed),
+		}).Warn("Routes had drifted from Toxiproxy")
+	}
+	s.setDriftStatus(status)
+	return status
+}
+
+// namedRoute is the route for a proxy Shrike didn't make that is named as it
+// would name the route for a prefix, such as one made by another tool or by an
+// earlier Shrike. A name that isn't what the prefix would give isn't a route's.
+func (s *ShrikeServer) namedRoute(proxy *toxy.Proxy) (Route, bool) {
+	prefix := store.PathNameFrom(s.cfg.ToxyPathSeparator, proxy.Name)
+	if !strings.HasPrefix(prefix, "/") || store.ProxyNameFrom(s.cfg.ToxyPathSeparator, prefix) != proxy.Name {
+		return Route{}, false
+	}
+	return Route{Prefix: prefix, Upstream: s.adoptedUpstream(proxy)}, true
+}
+
+// adoptedUpstream is the upstream for a route adopting the proxy: its own when
+// it isn't the default upstream, which is kept by leaving it empty.
+func (s *ShrikeServer) adoptedUpstream(proxy *toxy.Proxy) string {
+	if proxy.Upstream == upstreamAddr(s.upstream) {
+		return ""
+	}
+	if _, port, err := net.SplitHostPort(proxy.Upstream); err == nil && port == "443" {
+		return "https://" + proxy.Upstream
+	}
+	return "http://" + proxy.Upstream
+}
+
+func (s *ShrikeServer) setDriftStatus(status DriftStatus) {
+	s.mu.Lock()
+	s.driftStatus = &status
+	s.mu.Unlock()
+}
+
+// watchDrift checks for drift every interval until the server is closed.
+func (s *ShrikeServer) watchDrift(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.checkDrift()
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// GetDrift returns the result of the last drift check.
+func (s *ShrikeServer) GetDrift(w http.ResponseWriter, req *http.Request) {
+	s.mu.RLock()
+	status := s.driftStatus
+	s.mu.RUnlock()
+	if status == nil {
+		RespondWithError(w, http.StatusNotFound, JSONError{
+			Status:  "No Drift Check",
+			Message: "Drift has not been checked for yet.",
+		})
+		return
+	}
+
+	b, _ := json.Marshal(status)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
+
+// CheckDrift checks for drift now and returns the result.
+func (s *ShrikeServer) CheckDrift(w http.ResponseWriter, req *http.Request) {
+	status := s.checkDrift()
+	b, _ := json.Marshal(status)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
@@ -0,0 +1,151 @@
+package api
+
+import (
+	"fmt"
+	"net/http/httptest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/richardbolt/shrike/client"
+)
+
+var _ = Describe("Drift repair", func() {
+	var (
+		upstream *httptest.Server
+		cfg      Config
+		s        *ShrikeServer
+		api      *client.Client
+	)
+
+	BeforeEach(func() {
+		upstream = httptest.NewServer(nil)
+		cfg = testConfig(upstream.URL)
+	})
+
+	JustBeforeEach(func() {
+		s = startServer(cfg)
+		api = apiClient(cfg)
+	})
+
+	AfterEach(func() {
+		s.Close()
+		upstream.Close()
+	})
+
+	check := func() *client.DriftStatus {
+		status, err := api.CheckDrift()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(status.Error).To(BeEmpty())
+		return status
+	}
+
+	It("removes routes whose proxy is missing", func() {
+		route, err := api.CreateRoute(client.Route{Prefix: "/orders"})
+		Expect(err).NotTo(HaveOccurred())
+		proxy, err := s.client.Proxy(route.Name)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(proxy.Delete()).To(Succeed())
+
+		Expect(check().Removed).To(Equal([]string{route.Name}))
+		Expect(s.ProxyStore.Get(route.Name)).To(BeNil())
+		Expect(check().Removed).To(BeEmpty())
+	})
+
+	It("points routes at their proxy's new listen address, keeping their settings", func() {
+		route, err := api.CreateRoute(client.Route{Prefix: "/orders", Methods: []string{"POST"}})
+		Expect(err).NotTo(HaveOccurred())
+		proxy, err := s.client.Proxy(route.Name)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(proxy.Delete()).To(Succeed())
+		listen := fmt.Sprintf("127.0.0.1:%d", freePorts(1)[0])
+		_, err = s.client.CreateProxy(route.Name, listen, proxy.Upstream)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(check().Refreshed).To(Equal([]string{route.Name}))
+		r := s.ProxyStore.Get(route.Name)
+		Expect(r.Proxy.Listen).To(Equal(listen))
+		Expect(r.Methods).To(Equal([]string{"POST"}))
+	})
+
+	It("adopts Shrike's proxies with the route's full settings", func() {
+		owned := Route{
+			Prefix:  "/orders",
+			Methods: []string{"POST"},
+			Headers: map[string]string{"X-Tenant": "canary"},
+		}
+		name := s.routeName(owned)
+		_, err := s.client.CreateProxy(name, "127.0.0.1:0", upstreamAddr(s.upstream))
+		Expect(err).NotTo(HaveOccurred())
+		// As with a route from the state file that failed to load.
+		s.own(owned)
+
+		Expect(check().Adopted).To(Equal([]string{name}))
+		r := s.ProxyStore.Get(name)
+		Expect(r).NotTo(BeNil())
+		Expect(r.Methods).To(Equal([]string{"POST"}))
+		Expect(r.Headers).To(Equal(map[string]string{"X-Tenant": "canary"}))
+	})
+
+	It("leaves other proxies alone, even those named like a prefix", func() {
+		_, err := s.client.CreateProxy("__payments", "127.0.0.1:0", "payments:80")
+		Expect(err).NotTo(HaveOccurred())
+		_, err = s.client.CreateProxy("redis", "127.0.0.1:0", "redis:6379")
+		Expect(err).NotTo(HaveOccurred())
+
+		status := check()
+		Expect(status.Adopted).To(BeEmpty())
+		Expect(status.Ignored).To(Equal([]string{"__payments", "redis"}))
+		Expect(s.ProxyStore.ToMap()).To(BeEmpty())
+	})
+
+	It("doesn't bring back deleted routes", func() {
+		route, err := api.CreateRoute(client.Route{Prefix: "/orders"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(route.Delete()).To(Succeed())
+		_, err = s.client.CreateProxy(route.Name, "127.0.0.1:0", upstreamAddr(s.upstream))
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(check().Ignored).To(Equal([]string{route.Name}))
+		Expect(s.ProxyStore.Get(route.Name)).To(BeNil())
+	})
+
+	Context("adopting proxies named after a prefix", func() {
+		BeforeEach(func() {
+			cfg.DriftAdoptNamed = true
+		})
+
+		It("adopts a proxy created outside The Shrike, keeping its upstream", func() {
+			_, err := s.client.CreateProxy("__payments__v1", "127.0.0.1:0", "payments:80")
+			Expect(err).NotTo(HaveOccurred())
+			_, err = s.client.CreateProxy("__my~5Fservice", "127.0.0.1:0", upstreamAddr(s.upstream))
+			Expect(err).NotTo(HaveOccurred())
+
+			status := check()
+			Expect(status.Adopted).To(Equal([]string{"__my~5Fservice", "__payments__v1"}))
+			Expect(status.Ignored).To(BeEmpty())
+			payments := s.route("__payments__v1")
+			Expect(payments.Prefix).To(Equal("/payments/v1"))
+			Expect(payments.Upstream).To(Equal("http://payments:80"))
+			mine := s.route("__my~5Fservice")
+			Expect(mine.Prefix).To(Equal("/my_service"))
+			Expect(mine.Upstream).To(BeEmpty())
+
+			route, err := api.Route("/payments/v1")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(route.Name).To(Equal("__payments__v1"))
+		})
+
+		It("ignores proxies whose name isn't what a prefix would give", func() {
+			for _, name := range []string{"redis", "__my_service", "payments__"} {
+				_, err := s.client.CreateProxy(name, "127.0.0.1:0", "redis:6379")
+				Expect(err).NotTo(HaveOccurred())
+			}
+
+			status := check()
+			Expect(status.Adopted).To(BeEmpty())
+			Expect(status.Ignored).To(Equal([]string{"__my_service", "payments__", "redis"}))
+			Expect(s.ProxyStore.ToMap()).To(BeEmpty())
+		})
+	})
+})
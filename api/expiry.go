@@ -0,0 +1,182 @@
+package api
+
+import (
+	"errors"
+	"time"
+
+	toxy "github.com/Shopify/toxiproxy/client"
+	log "github.com/sirupsen/logrus"
+)
+
+// TimedToxic is a toxic with an optional expiry. When creating a toxic either
+// a Duration, such as "15m", or an ExpiresAt time can be given for it to be
+// removed automatically.
+type TimedToxic struct {
+	toxy.Toxic
+	Duration         string     `json:"duration,omitempty"`
+	ExpiresAt        *time.Time `json:"expires_at,omitempty"`
+	RemainingSeconds *float64   `json:"remaining_seconds,omitempty"`
+}
+
+// expiresAt returns when the toxic being created should expire, if at all.
+func (t TimedToxic) expiresAt(now time.Time) (*time.Time, error) {
+	if t.Duration != "" && t.ExpiresAt != nil {
+		return nil, errors.New("only one of duration and expires_at can be given")
+	}
+	if t.Duration != "" {
+		d, err := time.ParseDuration(t.Duration)
+		if err != nil {
+			return nil, err
+		}
+		if d <= 0 {
+			return nil, errors.New("duration must be positive")
+		}
+		at := now.Add(d)
+		return &at, nil
+	}
+	if t.ExpiresAt != nil && !t.ExpiresAt.After(now) {
+		return nil, errors.New("expires_at must be in the future")
+	}
+	return t.ExpiresAt, nil
+}
+
+// addToxic to the proxy, to be removed at expiresAt if it isn't nil.
+func (s *ShrikeServer) addToxic(proxy *toxy.Proxy, t toxy.Toxic, expiresAt *time.Time) (*toxy.Toxic, error) {
+	s.expiryMu.Lock()
+	defer s.expiryMu.Unlock()
+	added, err := proxy.AddToxic(t.Name, t.Type, t.Stream, t.Toxicity, t.Attributes)
+	if err == nil && expiresAt != nil {
+		s.setExpiry(proxy.Name, added.Name, *expiresAt)
+	}
+	return added, err
+}
+
+// removeToxic from the proxy, cancelling its expiry.
+func (s *ShrikeServer) removeToxic(proxy *toxy.Proxy, name string) error {
+	s.expiryMu.Lock()
+	defer s.expiryMu.Unlock()
+	err := proxy.RemoveToxic(name)
+	if err == nil {
+		s.clearExpiry(toxicKey{proxy.Name, name})
+	}
+	return err
+}
+
+type toxicKey struct {
+	route string
+	toxic string
+}
+
+// expiry is the timer that removes a toxic.
+type expiry struct {
+	at    time.Time
+	timer *time.Timer
+}
+
+// timedToxic returns the toxic with its expiry and time remaining, if it has one.
+func (s *ShrikeServer) timedToxic(route string, t toxy.Toxic) TimedToxic {
+	s.expiryMu.Lock()
+	defer s.expiryMu.Unlock()
+	tt := TimedToxic{Toxic: t}
+	if e, ok := s.expiries[toxicKey{route, t.Name}]; ok {
+		at := e.at
+		remaining := time.Until(at).Seconds()
+		if remaining < 0 {
+			remaining = 0
+		}
+		tt.ExpiresAt, tt.RemainingSeconds = &at, &remaining
+	}
+	return tt
+}
+
+// toxicExpiries returns when each timed toxic on the route expires.
+func (s *ShrikeServer) toxicExpiries(route string) map[string]time.Time {
+	s.expiryMu.Lock()
+	defer s.expiryMu.Unlock()
+	var expiries map[string]time.Time
+	for k, e := range s.expiries {
+		if k.route == route {
+			if expiries == nil {
+				expiries = map[string]time.Time{}
+			}
+			expiries[k.toxic] = e.at
+		}
+	}
+	return expiries
+}
+
+// expireToxic removes the toxic from the route at the given time.
+func (s *ShrikeServer) expireToxic(route, toxic string, at time.Time) {
+	s.expiryMu.Lock()
+	defer s.expiryMu.Unlock()
+	s.setExpiry(route, toxic, at)
+}
+
+// cancelExpiry stops the toxic from being removed when it expires.
+func (s *ShrikeServer) cancelExpiry(route, toxic string) {
+	s.expiryMu.Lock()
+	defer s.expiryMu.Unlock()
+	s.clearExpiry(toxicKey{route, toxic})
+}
+
+// cancelExpiries for every toxic on the route, or on every route when route is empty.
+func (s *ShrikeServer) cancelExpiries(route string) {
+	s.expiryMu.Lock()
+	defer s.expiryMu.Unlock()
+	for k := range s.expiries {
+		if route == "" || k.route == route {
+			s.clearExpiry(k)
+		}
+	}
+}
+
+// setExpiry replaces any expiry for the toxic. expiryMu must be held.
+func (s *ShrikeServer) setExpiry(route, toxic string, at time.Time) {
+	k := toxicKey{route, toxic}
+	s.clearExpiry(k)
+	e := &expiry{at: at}
+	e.timer = time.AfterFunc(time.Until(at), func() { s.expire(k, e) })
+	s.expiries[k] = e
+}
+
+// clearExpiry stops and forgets the toxic's expiry. expiryMu must be held.
+func (s *ShrikeServer) clearExpiry(k toxicKey) {
+	if e, ok := s.expiries[k]; ok {
+		e.timer.Stop()
+		delete(s.expiries, k)
+	}
+}
+
+// expire removes the toxic unless its expiry was cancelled or replaced while
+// the timer was firing. Holding changeMu and expiryMu while removing the toxic
+// keeps the removal from racing a delete or re-create of the toxic through the
+// API or a snapshot restore.
+func (s *ShrikeServer) expire(k toxicKey, e *expiry) {
+	s.changeMu.Lock()
+	defer s.changeMu.Unlock()
+	s.expiryMu.Lock()
+	if s.expiries[k] != e {
+		s.expiryMu.Unlock()
+		return
+	}
+	delete(s.expiries, k)
+	proxy, err := s.client.Proxy(k.route)
+	if err == nil {
+		err = proxy.RemoveToxic(k.toxic)
+	}
+	s.expiryMu.Unlock()
+
+	if err != nil {
+		log.WithFields(log.Fields{
+			"Route": k.route,
+			"Toxic": k.toxic,
+			"err":   err,
+		}).Error("Error removing expired toxic")
+		return
+	}
+	log.WithFields(log.Fields{
+		"Route": k.route,
+		"Toxic": k.toxic,
+	}).Info("Removed expired toxic")
+	s.changed()
+}
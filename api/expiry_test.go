@@ -0,0 +1,86 @@
+package api
+
+import (
+	"net/http/httptest"
+	"time"
+
+	toxy "github.com/Shopify/toxiproxy/client"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/richardbolt/shrike/client"
+)
+
+var _ = Describe("Timed toxics", func() {
+	Describe("expiresAt", func() {
+		now := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+
+		It("is now plus the duration", func() {
+			at, err := TimedToxic{Duration: "15m"}.expiresAt(now)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(*at).To(Equal(now.Add(15 * time.Minute)))
+		})
+
+		It("is nil for toxics without an expiry", func() {
+			Expect(TimedToxic{}.expiresAt(now)).To(BeNil())
+		})
+
+		It("rejects expiries that aren't in the future", func() {
+			past := now.Add(-time.Second)
+			for _, t := range []TimedToxic{
+				{Duration: "-1m"},
+				{Duration: "0s"},
+				{Duration: "soon"},
+				{ExpiresAt: &past},
+				{Duration: "1m", ExpiresAt: &now},
+			} {
+				_, err := t.expiresAt(now)
+				Expect(err).To(HaveOccurred())
+			}
+		})
+	})
+
+	Context("on a route", func() {
+		var (
+			upstream *httptest.Server
+			s        *ShrikeServer
+			route    *client.Route
+		)
+
+		BeforeEach(func() {
+			upstream = httptest.NewServer(nil)
+			cfg := testConfig(upstream.URL)
+			s = startServer(cfg)
+			var err error
+			route, err = apiClient(cfg).CreateRoute(client.Route{Prefix: "/orders"})
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		AfterEach(func() {
+			s.Close()
+			upstream.Close()
+		})
+
+		It("are removed when they expire", func() {
+			t, err := route.AddTimedToxic("slow", "latency", "", 1, toxy.Attributes{"latency": 10}, 200*time.Millisecond)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(t.ExpiresAt).NotTo(BeNil())
+			Expect(*t.RemainingSeconds).To(BeNumerically(">", 0))
+
+			Eventually(route.Toxics, time.Second, 50*time.Millisecond).Should(BeEmpty())
+			Expect(s.toxicExpiries(route.Name)).To(BeEmpty())
+		})
+
+		It("stop expiring when they're removed", func() {
+			_, err := route.AddTimedToxic("slow", "latency", "", 1, toxy.Attributes{"latency": 10}, 200*time.Millisecond)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(route.RemoveToxic("slow")).To(Succeed())
+			Expect(s.toxicExpiries(route.Name)).To(BeEmpty())
+
+			// Added again without an expiry, it stays.
+			_, err = route.AddToxic("slow", "latency", "", 1, toxy.Attributes{"latency": 10})
+			Expect(err).NotTo(HaveOccurred())
+			Consistently(route.Toxics, 400*time.Millisecond, 50*time.Millisecond).Should(HaveLen(1))
+		})
+	})
+})
@@ -0,0 +1,93 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+
+	"github.com/Shopify/toxiproxy"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/richardbolt/shrike/client"
+)
+
+var _ = Describe("External Toxiproxy", func() {
+	var (
+		upstream *httptest.Server
+		external *toxiproxy.ApiServer
+		toxyAPI  *httptest.Server
+		cfg      Config
+		s        *ShrikeServer
+	)
+
+	BeforeEach(func() {
+		upstream = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			w.Write([]byte("ok"))
+		}))
+		// Served from its own handler rather than Listen, which can only be used
+		// once in a process.
+		external = toxiproxy.NewServer()
+		toxyAPI = httptest.NewServer((&ShrikeServer{toxiproxy: external}).ToxiproxyHandler())
+		host, port, err := net.SplitHostPort(toxyAPI.Listener.Addr().String())
+		Expect(err).NotTo(HaveOccurred())
+
+		cfg = testConfig(upstream.URL)
+		cfg.ToxyExternal = true
+		cfg.ToxyAddress = host
+		cfg.ToxyAPIPort, err = strconv.Atoi(port)
+		Expect(err).NotTo(HaveOccurred())
+		// Toxiproxy reports the proxies listening on 127.0.0.1.
+		cfg.ToxyListenHost = "localhost"
+		s = startServer(cfg)
+	})
+
+	AfterEach(func() {
+		s.Close()
+		external.Collection.Clear()
+		toxyAPI.Close()
+		upstream.Close()
+	})
+
+	It("proxies requests by dialling the Toxiproxy listen host", func() {
+		route, err := apiClient(cfg).CreateRoute(client.Route{Prefix: "/orders"})
+		Expect(err).NotTo(HaveOccurred())
+		proxy, err := external.Collection.Get(route.Name)
+		Expect(err).NotTo(HaveOccurred())
+		_, port, err := net.SplitHostPort(proxy.Listen)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(proxy.Listen).NotTo(HavePrefix("localhost:"))
+
+		r := s.ProxyStore.Get(route.Name)
+		Expect(r.Dial).To(Equal(net.JoinHostPort("localhost", port)))
+
+		dialled := make(chan string, 1)
+		s.transport.mu.Lock()
+		s.transport.base = &http.Transport{
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				dialled <- addr
+				return (&net.Dialer{}).DialContext(ctx, network, addr)
+			},
+		}
+		s.transport.mu.Unlock()
+		resp, err := http.Get(fmt.Sprintf("http://%s/orders/1", s.Addr()))
+		Expect(err).NotTo(HaveOccurred())
+		defer resp.Body.Close()
+		b, _ := ioutil.ReadAll(resp.Body)
+		Expect(resp.StatusCode).To(Equal(http.StatusOK))
+		Expect(string(b)).To(Equal("ok"))
+		Expect(dialled).To(Receive(Equal(r.Dial)))
+	})
+
+	It("leaves the proxies in the external Toxiproxy when closed", func() {
+		route, err := apiClient(cfg).CreateRoute(client.Route{Prefix: "/orders"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(s.Close()).To(Succeed())
+		_, err = external.Collection.Get(route.Name)
+		Expect(err).NotTo(HaveOccurred())
+	})
+})
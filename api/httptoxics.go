@@ -0,0 +1,420 @@
+package api
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+
+	toxy "github.com/Shopify/toxiproxy/client"
+	log "github.com/sirupsen/logrus"
+)
+
+// HTTP toxic types.
+const (
+	// HTTPToxicStatus responds with a canned status and body instead of forwarding.
+	// Attributes: status_code (default 503), body and content_type.
+	HTTPToxicStatus = "status"
+	// HTTPToxicAbort resets the client connection. Attributes: bytes, the number of
+	// response bytes sent before the reset. The default of 0 resets before forwarding.
+	HTTPToxicAbort = "abort"
+)
+
+var errAborted = errors.New("connection aborted by an http toxic")
+
+// HTTPToxic is a fault applied at the HTTP level to requests on a route.
+// Toxicity is the probability of it being applied to a request, as with Toxiproxy.
+type HTTPToxic struct {
+	Name       string          `json:"name"`
+	Type       string          `json:"type"`
+	Toxicity   float32         `json:"toxicity"`
+	Attributes toxy.Attributes `json:"attributes"`
+}
+
+// HTTPToxics is a list of HTTP toxics.
+type HTTPToxics []HTTPToxic
+
+// Validate the toxic's type and attributes.
+func (t HTTPToxic) Validate() error {
+	if t.Toxicity < 0 || t.Toxicity > 1 {
+		return fmt.Errorf("http toxic %q toxicity must be between 0 and 1", t.Name)
+	}
+	switch t.Type {
+	case HTTPToxicStatus:
+		code, err := t.intAttr("status_code", http.StatusServiceUnavailable)
+		if err != nil {
+			return err
+		}
+		if code < 100 || code > 599 {
+			return fmt.Errorf("http toxic %q status_code must be between 100 and 599", t.Name)
+		}
+	case HTTPToxicAbort:
+		n, err := t.intAttr("bytes", 0)
+		if err != nil {
+			return err
+		}
+		if n < 0 {
+			return fmt.Errorf("http toxic %q bytes must not be negative", t.Name)
+		}
+	default:
+		return fmt.Errorf("http toxic %q type must be %s or %s", t.Name, HTTPToxicStatus, HTTPToxicAbort)
+	}
+	return nil
+}
+
+// UnmarshalJSON defaults toxicity to 1 when it isn't given, as Toxiproxy does.
+func (t *HTTPToxic) UnmarshalJSON(b []byte) error {
+	type plain HTTPToxic
+	p := plain{Toxicity: 1}
+	if err := json.Unmarshal(b, &p); err != nil {
+		return err
+	}
+	*t = HTTPToxic(p)
+	return nil
+}
+
+// UnmarshalYAML defaults toxicity to 1 when it isn't given, as Toxiproxy does.
+func (t *HTTPToxic) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain HTTPToxic
+	p := plain{Toxicity: 1}
+	if err := unmarshal(&p); err != nil {
+		return err
+	}
+	*t = HTTPToxic(p)
+	return nil
+}
+
+func (t HTTPToxic) intAttr(key string, def int) (int, error) {
+	switch v := t.Attributes[key].(type) {
+	case nil:
+		return def, nil
+	case int:
+		return v, nil
+	case float64:
+		return int(v), nil
+	default:
+		return 0, fmt.Errorf("http toxic %q %s must be a number", t.Name, key)
+	}
+}
+
+func (t HTTPToxic) stringAttr(key string, def string) string {
+	if v, ok := t.Attributes[key].(string); ok {
+		return v
+	}
+	return def
+}
+
+// applyHTTPToxics applies the toxics that fire for this request, adding their
+// names to fired. It returns the writer to forward the request with, or false
+// when a toxic has already dealt with the request and it must not be forwarded.
+func applyHTTPToxics(w http.ResponseWriter, toxics HTTPToxics, fired *[]string) (http.ResponseWriter, bool) {
+	for _, t := range toxics {
+		if rand.Float32() >= t.Toxicity {
+			continue
+		}
+		*fired = append(*fired, t.Name)
+		switch t.Type {
+		case HTTPToxicStatus:
+			code, _ := t.intAttr("status_code", http.StatusServiceUnavailable)
+			w.Header().Set("Content-Type", t.stringAttr("content_type", "text/plain; charset=utf-8"))
+			w.WriteHeader(code)
+			io.WriteString(w, t.stringAttr("body", http.StatusText(code)))
+			return w, false
+		case HTTPToxicAbort:
+			n, _ := t.intAttr("bytes", 0)
+			if n == 0 {
+				if err := abort(w); err != nil {
+					// HTTP/2 responses can't be hijacked, so the server resets
+					// the stream instead. See recoverer.
+					panic(http.ErrAbortHandler)
+				}
+				return w, false
+			}
+			w = &abortWriter{ResponseWriter: w, remaining: n}
+		}
+	}
+	return w, true
+}
+
+// abort the client connection, with a reset rather than a clean close where possible.
+func abort(w http.ResponseWriter) error {
+	h, ok := w.(http.Hijacker)
+	if !ok {
+		return errAborted
+	}
+	conn, _, err := h.Hijack()
+	if err != nil {
+		return err
+	}
+	if tcp, ok := conn.(*net.TCPConn); ok {
+		tcp.SetLinger(0)
+	}
+	return conn.Close()
+}
+
+// abortWriter aborts the client connection once remaining bytes have been written.
+type abortWriter struct {
+	http.ResponseWriter
+	remaining int
+	aborted   bool
+}
+
+func (a *abortWriter) Write(b []byte) (int, error) {
+	if a.aborted {
+		return 0, errAborted
+	}
+	if len(b) < a.remaining {
+		a.remaining -= len(b)
+		return a.ResponseWriter.Write(b)
+	}
+	n, _ := a.ResponseWriter.Write(b[:a.remaining])
+	if f, ok := a.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+	a.aborted = true
+	if err := abort(a.ResponseWriter); err != nil {
+		panic(http.ErrAbortHandler)
+	}
+	return n, errAborted
+}
+
+// Flush passes through to the underlying writer.
+func (a *abortWriter) Flush() {
+	if f, ok := a.ResponseWriter.(http.Flusher); ok && !a.aborted {
+		f.Flush()
+	}
+}
+
+// Hijack passes through to the underlying writer for WebSockets.
+func (a *abortWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := a.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response writer can not be hijacked")
+	}
+	return h.Hijack()
+}
+
+// httpToxics on the route by name. The returned slice must not be modified.
+func (s *ShrikeServer) httpToxics(name string) HTTPToxics {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.routeHTTPToxics[name]
+}
+
+// setHTTPToxics replaces the toxics on the route by name.
+func (s *ShrikeServer) setHTTPToxics(name string, toxics HTTPToxics) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(toxics) == 0 {
+		delete(s.routeHTTPToxics, name)
+		return
+	}
+	s.routeHTTPToxics[name] = toxics
+}
+
+// routeParam returns the route URL param, or false with an error response
+// written if there's no such route.
+func (s *ShrikeServer) routeParam(w http.ResponseWriter, req *http.Request) (string, bool) {
+	route := s.routeRef(req)
+	if route == "" {
+		log.WithField("Route", route).Info("Route must be the name of one of the proxy paths.")
+		RespondWithError(w, http.StatusBadRequest, JSONError{
+			Status:  "Bad Request",
+			Message: "Route must be the name of one of the proxy paths.",
+		})
+		return "", false
+	}
+
+	if s.ProxyStore.Get(route) == nil {
+		log.WithField("Route", route).Info("Error getting proxy from the store")
+		RespondWithError(w, http.StatusNotFound, JSONError{
+			Status:  "No Proxy",
+			Message: "No proxy by that name.",
+		})
+		return "", false
+	}
+	return route, true
+}
+
+// GetHTTPToxics for the given route.
+func (s *ShrikeServer) GetHTTPToxics(w http.ResponseWriter, req *http.Request) {
+	name, ok := s.routeParam(w, req)
+	if !ok {
+		return
+	}
+
+	toxics := s.httpToxics(name)
+	if toxics == nil {
+		toxics = HTTPToxics{}
+	}
+	b, _ := json.Marshal(toxics)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
+
+// CreateHTTPToxic on the given route
+func (s *ShrikeServer) CreateHTTPToxic(w http.ResponseWriter, req *http.Request) {
+	defer req.Body.Close()
+	body, _ := ioutil.ReadAll(req.Body)
+	doc := &HTTPToxic{}
+	if err := json.Unmarshal(body, &doc); err != nil || doc.Type == "" {
+		log.Errorf("Error unmarshaling body %s", err)
+		RespondWithError(w, http.StatusBadRequest, JSONError{
+			Status:  "Bad Request",
+			Message: "Request body is not a valid JSON HTTP Toxic object.",
+		})
+		return
+	}
+	if doc.Name == "" {
+		doc.Name = doc.Type
+	}
+	if err := doc.Validate(); err != nil {
+		log.WithField("err", err).Info("Invalid http toxic")
+		RespondWithError(w, http.StatusBadRequest, JSONError{
+			Status:  "Bad Request",
+			Message: err.Error(),
+		})
+		return
+	}
+
+	s.changeMu.Lock()
+	defer s.changeMu.Unlock()
+	name, ok := s.routeParam(w, req)
+	if !ok {
+		return
+	}
+
+	toxics := s.httpToxics(name)
+	for _, t := range toxics {
+		if t.Name == doc.Name {
+			RespondWithError(w, http.StatusConflict, JSONError{
+				Status:  "Conflict",
+				Message: "An http toxic by that name already exists.",
+			})
+			return
+		}
+	}
+	s.setHTTPToxics(name, append(append(HTTPToxics{}, toxics...), *doc))
+	s.changed()
+
+	b, _ := json.Marshal(doc)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
+
+// GetHTTPToxic from the route
+func (s *ShrikeServer) GetHTTPToxic(w http.ResponseWriter, req *http.Request) {
+	name, ok := s.routeParam(w, req)
+	if !ok {
+		return
+	}
+
+	toxic := pathParam(req, "toxic")
+	for _, t := range s.httpToxics(name) {
+		if t.Name == toxic {
+			b, _ := json.Marshal(t)
+			w.Header().Set("Content-Type", "application/json")
+			w.Write(b)
+			return
+		}
+	}
+	RespondWithError(w, http.StatusNotFound, JSONError{
+		Status:  "No Toxic",
+		Message: "No http toxic by that name.",
+	})
+}
+
+// UpdateHTTPToxic on the route. Only the toxicity and attributes can be changed,
+// and each is left as it is when not given.
+func (s *ShrikeServer) UpdateHTTPToxic(w http.ResponseWriter, req *http.Request) {
+	defer req.Body.Close()
+	body, _ := ioutil.ReadAll(req.Body)
+	doc := &struct {
+		Toxicity   *float32        `json:"toxicity"`
+		Attributes toxy.Attributes `json:"attributes"`
+	}{}
+	if err := json.Unmarshal(body, &doc); err != nil {
+		log.Errorf("Error unmarshaling body %s", err)
+		RespondWithError(w, http.StatusBadRequest, JSONError{
+			Status:  "Bad Request",
+			Message: "Request body is not a valid JSON HTTP Toxic object.",
+		})
+		return
+	}
+
+	s.changeMu.Lock()
+	defer s.changeMu.Unlock()
+	name, ok := s.routeParam(w, req)
+	if !ok {
+		return
+	}
+
+	toxic := pathParam(req, "toxic")
+	toxics := append(HTTPToxics{}, s.httpToxics(name)...)
+	for i, t := range toxics {
+		if t.Name != toxic {
+			continue
+		}
+		if doc.Toxicity != nil {
+			t.Toxicity = *doc.Toxicity
+		}
+		if doc.Attributes != nil {
+			t.Attributes = doc.Attributes
+		}
+		if err := t.Validate(); err != nil {
+			RespondWithError(w, http.StatusBadRequest, JSONError{
+				Status:  "Bad Request",
+				Message: err.Error(),
+			})
+			return
+		}
+		toxics[i] = t
+		s.setHTTPToxics(name, toxics)
+		s.changed()
+
+		b, _ := json.Marshal(t)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(b)
+		return
+	}
+	RespondWithError(w, http.StatusNotFound, JSONError{
+		Status:  "No Toxic",
+		Message: "No http toxic by that name.",
+	})
+}
+
+// DeleteHTTPToxic from the route
+func (s *ShrikeServer) DeleteHTTPToxic(w http.ResponseWriter, req *http.Request) {
+	s.changeMu.Lock()
+	defer s.changeMu.Unlock()
+	name, ok := s.routeParam(w, req)
+	if !ok {
+		return
+	}
+
+	toxic := pathParam(req, "toxic")
+	current := s.httpToxics(name)
+	toxics := HTTPToxics{}
+	for _, t := range current {
+		if t.Name != toxic {
+			toxics = append(toxics, t)
+		}
+	}
+	if len(toxics) == len(current) {
+		RespondWithError(w, http.StatusNotFound, JSONError{
+			Status:  "No Toxic",
+			Message: "No http toxic by that name.",
+		})
+		return
+	}
+	s.setHTTPToxics(name, toxics)
+	s.changed()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,130 @@
+package api
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+
+	toxy "github.com/Shopify/toxiproxy/client"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/richardbolt/shrike/client"
+)
+
+var _ = Describe("HTTP toxics", func() {
+	var (
+		upstream *httptest.Server
+		cfg      Config
+		s        *ShrikeServer
+		route    *client.Route
+	)
+
+	start := func(change func(*Config)) {
+		cfg = testConfig(upstream.URL)
+		if change != nil {
+			change(&cfg)
+		}
+		s = startServer(cfg)
+		var err error
+		route, err = apiClient(cfg).CreateRoute(client.Route{Prefix: "/orders"})
+		Expect(err).NotTo(HaveOccurred())
+	}
+
+	BeforeEach(func() {
+		upstream = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			w.Write([]byte(strings.Repeat("x", 4096)))
+		}))
+	})
+
+	AfterEach(func() {
+		s.Close()
+		upstream.Close()
+	})
+
+	It("responds with the status toxic's status", func() {
+		start(nil)
+		_, err := route.AddHTTPToxic("", HTTPToxicStatus, 1, toxy.Attributes{"status_code": 502, "body": "bad gateway"})
+		Expect(err).NotTo(HaveOccurred())
+
+		resp, err := http.Get(fmt.Sprintf("http://127.0.0.1:%d/orders/1", cfg.Port))
+		Expect(err).NotTo(HaveOccurred())
+		defer resp.Body.Close()
+		Expect(resp.StatusCode).To(Equal(http.StatusBadGateway))
+		Expect(ioutil.ReadAll(resp.Body)).To(BeEquivalentTo("bad gateway"))
+	})
+
+	Context("aborting HTTP/2 requests", func() {
+		var get func() (*http.Response, error)
+
+		BeforeEach(func() {
+			start(func(c *Config) { c.TLSSelfSigned = true })
+			get = func() (*http.Response, error) {
+				return tlsClient().Get(fmt.Sprintf("https://127.0.0.1:%d/orders/1", cfg.Port))
+			}
+		})
+
+		It("resets the stream rather than responding with a 500", func() {
+			_, err := route.AddHTTPToxic("", HTTPToxicAbort, 1, nil)
+			Expect(err).NotTo(HaveOccurred())
+
+			resp, err := get()
+			if err == nil {
+				resp.Body.Close()
+			}
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("stream error"))
+		})
+
+		It("resets the stream after the given bytes", func() {
+			_, err := route.AddHTTPToxic("", HTTPToxicAbort, 1, toxy.Attributes{"bytes": 100})
+			Expect(err).NotTo(HaveOccurred())
+
+			resp, err := get()
+			Expect(err).NotTo(HaveOccurred())
+			defer resp.Body.Close()
+			Expect(resp.ProtoMajor).To(Equal(2))
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+			b, err := ioutil.ReadAll(resp.Body)
+			Expect(err).To(HaveOccurred())
+			Expect(len(b)).To(BeNumerically("<=", 100))
+		})
+	})
+
+	It("keeps every toxic added at once", func() {
+		start(nil)
+		var wg sync.WaitGroup
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				defer GinkgoRecover()
+				_, err := route.AddHTTPToxic(fmt.Sprintf("status-%d", i), HTTPToxicStatus, 0, nil)
+				Expect(err).NotTo(HaveOccurred())
+			}(i)
+		}
+		wg.Wait()
+		Expect(route.HTTPToxics()).To(HaveLen(20))
+	})
+
+	It("keeps the toxicity when an update only changes the attributes", func() {
+		start(nil)
+		_, err := route.AddHTTPToxic("flaky", HTTPToxicStatus, 0.5, toxy.Attributes{"status_code": 502})
+		Expect(err).NotTo(HaveOccurred())
+
+		req, _ := http.NewRequest("POST", fmt.Sprintf("http://127.0.0.1:%d/routes/%s/http-toxics/flaky", cfg.APIPort, route.Name),
+			strings.NewReader(`{"attributes": {"status_code": 504}}`))
+		resp, err := http.DefaultClient.Do(req)
+		Expect(err).NotTo(HaveOccurred())
+		resp.Body.Close()
+		Expect(resp.StatusCode).To(Equal(http.StatusOK))
+
+		toxics, err := route.HTTPToxics()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(toxics[0].Toxicity).To(BeNumerically("==", 0.5))
+		Expect(toxics[0].Attributes["status_code"]).To(BeNumerically("==", 504))
+	})
+})
@@ -0,0 +1,70 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Lifecycle", func() {
+	var (
+		upstream *httptest.Server
+		arrived  chan struct{}
+		cfg      Config
+	)
+
+	BeforeEach(func() {
+		arrived = make(chan struct{}, 1)
+		upstream = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			arrived <- struct{}{}
+			time.Sleep(200 * time.Millisecond)
+			w.Write([]byte("done"))
+		}))
+		cfg = testConfig(upstream.URL)
+	})
+
+	AfterEach(func() {
+		upstream.Close()
+	})
+
+	It("drains the requests in flight on Shutdown, then Wait returns nil", func() {
+		s := startServer(cfg)
+		defer s.Close()
+		waited := make(chan error, 1)
+		go func() {
+			waited <- s.Wait()
+		}()
+
+		type result struct {
+			body string
+			err  error
+		}
+		done := make(chan result, 1)
+		go func() {
+			resp, err := http.Get(fmt.Sprintf("http://%s/orders/1", s.Addr()))
+			if err != nil {
+				done <- result{err: err}
+				return
+			}
+			defer resp.Body.Close()
+			b, err := ioutil.ReadAll(resp.Body)
+			done <- result{string(b), err}
+		}()
+		Eventually(arrived).Should(Receive())
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		Expect(s.Shutdown(ctx)).To(Succeed())
+		var r result
+		Expect(done).To(Receive(&r))
+		Expect(r.err).NotTo(HaveOccurred())
+		Expect(r.body).To(Equal("done"))
+		Eventually(waited).Should(Receive(BeNil()))
+	})
+})
@@ -0,0 +1,115 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/richardbolt/shrike/store"
+	log "github.com/sirupsen/logrus"
+)
+
+// defaultRoute labels requests that matched no route and went to the upstream.
+const defaultRoute = "default"
+
+// metrics for the proxied traffic and the state of the routes.
+type metrics struct {
+	registry *prometheus.Registry
+	requests *prometheus.CounterVec
+	duration *prometheus.HistogramVec
+}
+
+func newMetrics(s *ShrikeServer) *metrics {
+	m := &metrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "shrike_requests_total",
+			Help: "Proxied requests by route and response status code. A code of 0 means the connection was hijacked, such as by an abort toxic or a websocket.",
+		}, []string{"route", "prefix", "code"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "shrike_request_duration_seconds",
+			Help:    "Time taken to proxy requests by route.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"route", "prefix"}),
+	}
+	m.registry.MustRegister(m.requests, m.duration, &routeCollector{s: s})
+	return m
+}
+
+// observe a proxied request. The route is nil for requests sent to the upstream.
+func (m *metrics) observe(r *store.Route, code int, d time.Duration) {
+	name, prefix := defaultRoute, defaultRoute
+	if r != nil {
+		name, prefix = r.Name, r.Prefix
+	}
+	m.requests.WithLabelValues(name, prefix, strconv.Itoa(code)).Inc()
+	m.duration.WithLabelValues(name, prefix).Observe(d.Seconds())
+}
+
+// handler serves the metrics.
+func (m *metrics) handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+var (
+	routesDesc = prometheus.NewDesc(
+		"shrike_routes",
+		"Number of routes.",
+		nil, nil,
+	)
+	routeEnabledDesc = prometheus.NewDesc(
+		"shrike_route_enabled",
+		"Whether the route's Toxiproxy proxy is enabled.",
+		[]string{"route", "prefix"}, nil,
+	)
+	routeToxicsDesc = prometheus.NewDesc(
+		"shrike_route_toxics",
+		"Active Toxiproxy toxics on the route.",
+		[]string{"route", "prefix"}, nil,
+	)
+	routeHTTPToxicsDesc = prometheus.NewDesc(
+		"shrike_route_http_toxics",
+		"HTTP toxics on the route.",
+		[]string{"route", "prefix"}, nil,
+	)
+)
+
+// routeCollector reports the routes and their toxics from Toxiproxy at scrape time.
+type routeCollector struct {
+	s *ShrikeServer
+}
+
+// Describe the route metrics.
+func (c *routeCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- routesDesc
+	ch <- routeEnabledDesc
+	ch <- routeToxicsDesc
+	ch <- routeHTTPToxicsDesc
+}
+
+// Collect the route metrics.
+func (c *routeCollector) Collect(ch chan<- prometheus.Metric) {
+	routes := c.s.ProxyStore.ToMap()
+	ch <- prometheus.MustNewConstMetric(routesDesc, prometheus.GaugeValue, float64(len(routes)))
+
+	proxies, err := c.s.client.Proxies()
+	if err != nil {
+		log.WithField("err", err).Error("Error getting proxies for metrics")
+		return
+	}
+	for name, r := range routes {
+		proxy := proxies[r.Proxy.Name]
+		if proxy == nil {
+			continue
+		}
+		enabled := 0.0
+		if proxy.Enabled {
+			enabled = 1
+		}
+		ch <- prometheus.MustNewConstMetric(routeEnabledDesc, prometheus.GaugeValue, enabled, name, r.Prefix)
+		ch <- prometheus.MustNewConstMetric(routeToxicsDesc, prometheus.GaugeValue, float64(len(proxy.ActiveToxics)), name, r.Prefix)
+		ch <- prometheus.MustNewConstMetric(routeHTTPToxicsDesc, prometheus.GaugeValue, float64(len(c.s.httpToxics(name))), name, r.Prefix)
+	}
+}
@@ -0,0 +1,67 @@
+package api
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+
+	toxy "github.com/Shopify/toxiproxy/client"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/richardbolt/shrike/client"
+)
+
+var _ = Describe("Metrics", func() {
+	var (
+		upstream *httptest.Server
+		cfg      Config
+		s        *ShrikeServer
+	)
+
+	BeforeEach(func() {
+		upstream = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			w.Write([]byte("ok"))
+		}))
+		cfg = testConfig(upstream.URL)
+		s = startServer(cfg)
+	})
+
+	AfterEach(func() {
+		s.Close()
+		upstream.Close()
+	})
+
+	get := func(port int, path string) string {
+		resp, err := http.Get(fmt.Sprintf("http://127.0.0.1:%d%s", port, path))
+		Expect(err).NotTo(HaveOccurred())
+		defer resp.Body.Close()
+		b, err := ioutil.ReadAll(resp.Body)
+		Expect(err).NotTo(HaveOccurred())
+		return string(b)
+	}
+
+	It("counts and times proxied requests by route and reports the routes", func() {
+		route, err := apiClient(cfg).CreateRoute(client.Route{Prefix: "/orders"})
+		Expect(err).NotTo(HaveOccurred())
+		_, err = route.AddToxic("slow", "latency", "", 1, toxy.Attributes{"latency": 1})
+		Expect(err).NotTo(HaveOccurred())
+		_, err = route.AddHTTPToxic("", HTTPToxicStatus, 1, toxy.Attributes{"status_code": 503})
+		Expect(err).NotTo(HaveOccurred())
+
+		get(cfg.Port, "/orders/1")
+		get(cfg.Port, "/orders/2")
+		get(cfg.Port, "/payments")
+
+		metrics := get(cfg.APIPort, "/metrics")
+		Expect(metrics).To(ContainSubstring(`shrike_requests_total{code="503",prefix="/orders",route="__orders"} 2`))
+		Expect(metrics).To(ContainSubstring(`shrike_requests_total{code="200",prefix="default",route="default"} 1`))
+		Expect(metrics).To(ContainSubstring(`shrike_request_duration_seconds_count{prefix="/orders",route="__orders"} 2`))
+		Expect(metrics).To(ContainSubstring(`shrike_request_duration_seconds_bucket{prefix="/orders",route="__orders",le="+Inf"} 2`))
+		Expect(metrics).To(ContainSubstring(`shrike_routes 1`))
+		Expect(metrics).To(ContainSubstring(`shrike_route_enabled{prefix="/orders",route="__orders"} 1`))
+		Expect(metrics).To(ContainSubstring(`shrike_route_toxics{prefix="/orders",route="__orders"} 1`))
+		Expect(metrics).To(ContainSubstring(`shrike_route_http_toxics{prefix="/orders",route="__orders"} 1`))
+	})
+})
@@ -0,0 +1,118 @@
+package api
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Persister saves the route configuration on every change and loads it on boot
+// so the routes and toxics survive a restart.
+type Persister interface {
+	Load() ([]RouteConfig, error)
+	Save([]RouteConfig) error
+}
+
+// NewFilePersister returns a Persister storing routes as JSON in the file at path.
+func NewFilePersister(path string) *FilePersister {
+	return &FilePersister{path: path}
+}
+
+// FilePersister is a Persister backed by a JSON file on local disk.
+type FilePersister struct {
+	path string
+}
+
+// Load the routes from the file. A missing file has no routes.
+func (f *FilePersister) Load() ([]RouteConfig, error) {
+	b, err := ioutil.ReadFile(f.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	routes := []RouteConfig{}
+	if err := json.Unmarshal(b, &routes); err != nil {
+		return nil, err
+	}
+	return routes, nil
+}
+
+// Save the routes, replacing the file atomically.
+func (f *FilePersister) Save(routes []RouteConfig) error {
+	b, err := json.MarshalIndent(routes, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(f.path), filepath.Base(f.path))
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), f.path)
+}
+
+// changed persists the routes and updates the toxic names in the access log
+// after the routes or toxics change.
+func (s *ShrikeServer) changed() {
+	s.persist()
+	if s.accessLog != nil {
+		s.accessLog.toxics.refresh()
+	}
+}
+
+// persist the current routes if a Persister is configured.
+func (s *ShrikeServer) persist() {
+	if s.cfg.Persister == nil {
+		return
+	}
+	s.persistMu.Lock()
+	defer s.persistMu.Unlock()
+	routes, err := s.routeConfigs()
+	if err == nil {
+		err = s.cfg.Persister.Save(routes)
+	}
+	if err != nil {
+		log.WithField("err", err).Error("Error persisting routes")
+	}
+}
+
+// restore the persisted routes once Toxiproxy is up.
+func (s *ShrikeServer) restore() {
+	if s.cfg.Persister == nil {
+		return
+	}
+	routes, err := s.cfg.Persister.Load()
+	if err != nil {
+		log.WithField("err", err).Error("Error loading persisted routes")
+		return
+	}
+	if err := s.loadRoutes(routes, "state file"); err != nil {
+		log.WithField("err", err).Error("Error restoring persisted routes")
+	}
+}
+
+// waitForToxiproxy polls the Toxiproxy API until it responds or timeout passes.
+func (s *ShrikeServer) waitForToxiproxy(timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		_, err := s.client.Proxies()
+		if err == nil || time.Now().After(deadline) {
+			return err
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
@@ -0,0 +1,108 @@
+package api
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"time"
+
+	toxy "github.com/Shopify/toxiproxy/client"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/richardbolt/shrike/client"
+)
+
+var _ = Describe("Persisting routes", func() {
+	var (
+		upstream *httptest.Server
+		dir      string
+		path     string
+		cfg      Config
+	)
+
+	BeforeEach(func() {
+		upstream = httptest.NewServer(nil)
+		var err error
+		dir, err = ioutil.TempDir("", "shrike-state")
+		Expect(err).NotTo(HaveOccurred())
+		path = filepath.Join(dir, "state.json")
+		cfg = testConfig(upstream.URL)
+		cfg.Persister = NewFilePersister(path)
+	})
+
+	AfterEach(func() {
+		upstream.Close()
+		os.RemoveAll(dir)
+	})
+
+	It("restores the routes and toxics on the next start", func() {
+		s := startServer(cfg)
+		api := apiClient(cfg)
+		orders, err := api.CreateRoute(client.Route{Prefix: "/orders", Methods: []string{"POST"}})
+		Expect(err).NotTo(HaveOccurred())
+		_, err = orders.AddToxic("slow", "latency", "downstream", 0.5, toxy.Attributes{"latency": 100})
+		Expect(err).NotTo(HaveOccurred())
+		_, err = orders.AddTimedToxic("cut", "bandwidth", "", 1, toxy.Attributes{"rate": 10}, time.Hour)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = orders.AddHTTPToxic("", HTTPToxicStatus, 1, toxy.Attributes{"status_code": 503})
+		Expect(err).NotTo(HaveOccurred())
+		users, err := api.CreateRoute(client.Route{Prefix: "/users"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(users.Disable()).To(Succeed())
+		s.Close()
+
+		s = startServer(cfg)
+		defer s.Close()
+		routes, err := api.Routes()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(routes).To(HaveLen(2))
+
+		orders, err = api.Route(orders.Name)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(orders.Methods).To(Equal([]string{"POST"}))
+		Expect(orders.Proxy.Enabled).To(BeTrue())
+		toxics, err := orders.Toxics()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(toxics).To(HaveLen(2))
+		for _, t := range toxics {
+			switch t.Name {
+			case "slow":
+				Expect(t.Stream).To(Equal("downstream"))
+				Expect(t.Toxicity).To(BeNumerically("==", 0.5))
+				Expect(t.ExpiresAt).To(BeNil())
+			case "cut":
+				Expect(t.ExpiresAt).NotTo(BeNil())
+				Expect(*t.ExpiresAt).To(BeTemporally("~", time.Now().Add(time.Hour), time.Minute))
+			default:
+				Fail("unexpected toxic " + t.Name)
+			}
+		}
+		httpToxics, err := orders.HTTPToxics()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(httpToxics).To(HaveLen(1))
+		Expect(httpToxics[0].Type).To(Equal(HTTPToxicStatus))
+
+		users, err = api.Route(users.Name)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(users.Proxy.Enabled).To(BeFalse())
+	})
+
+	It("starts without routes when the state file is missing", func() {
+		Expect(NewFilePersister(path).Load()).To(BeEmpty())
+		s := startServer(cfg)
+		defer s.Close()
+		Expect(apiClient(cfg).Routes()).To(BeEmpty())
+	})
+
+	It("starts without routes when the state file is corrupt", func() {
+		Expect(ioutil.WriteFile(path, []byte("{not json"), 0600)).To(Succeed())
+		_, err := NewFilePersister(path).Load()
+		Expect(err).To(HaveOccurred())
+
+		s := startServer(cfg)
+		defer s.Close()
+		Expect(apiClient(cfg).Routes()).To(BeEmpty())
+	})
+})
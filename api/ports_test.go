@@ -0,0 +1,55 @@
+package api
+
+import (
+	"fmt"
+	"net"
+	"net/http/httptest"
+	"strconv"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/richardbolt/shrike/client"
+	"github.com/richardbolt/shrike/store"
+)
+
+var _ = Describe("Proxy ports", func() {
+	It("moves on to the next free port when one is in use by another process", func() {
+		upstream := httptest.NewServer(nil)
+		defer upstream.Close()
+
+		// Hold a port with the one after it free for the range.
+		var held net.Listener
+		for held == nil {
+			ln, err := net.Listen("tcp", "127.0.0.1:0")
+			Expect(err).NotTo(HaveOccurred())
+			next, err := net.Listen("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(ln.Addr().(*net.TCPAddr).Port+1)))
+			if err != nil {
+				ln.Close()
+				continue
+			}
+			next.Close()
+			held = ln
+		}
+		defer held.Close()
+		port := held.Addr().(*net.TCPAddr).Port
+
+		cfg := testConfig(upstream.URL)
+		cfg.ToxyEphemeralPorts = false
+		cfg.ToxyPortMin, cfg.ToxyPortMax = port, port+1
+		s := startServer(cfg)
+		defer s.Close()
+
+		// A prefix whose proxy is tried on the held port first.
+		prefix := ""
+		for i := 0; prefix == ""; i++ {
+			p := fmt.Sprintf("/route-%d", i)
+			if first, _ := store.NewPortAllocator(port, port+1).Allocate(s.routeName(Route{Prefix: p})); first == port {
+				prefix = p
+			}
+		}
+		r, err := apiClient(cfg).CreateRoute(client.Route{Prefix: prefix})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(r.Proxy.Listen).To(Equal(net.JoinHostPort("127.0.0.1", strconv.Itoa(port+1))))
+	})
+})
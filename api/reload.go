@@ -0,0 +1,140 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// ConfigStatus is the result of the last reload of the route config file.
+type ConfigStatus struct {
+	Time    time.Time  `json:"time"`
+	Success bool       `json:"success"`
+	Error   string     `json:"error,omitempty"`
+	Diff    ConfigDiff `json:"diff"`
+}
+
+// ConfigDiff holds the changes a reload applied to the live routes.
+type ConfigDiff struct {
+	Added   []string    `json:"added"`
+	Removed []string    `json:"removed"`
+	Changed []RouteDiff `json:"changed"`
+}
+
+// RouteDiff holds the changes made to an existing route.
+type RouteDiff struct {
+	Name          string   `json:"name"`
+	Enabled       *bool    `json:"enabled,omitempty"`
+	ToxicsAdded   []string `json:"toxics_added,omitempty"`
+	ToxicsUpdated []string `json:"toxics_updated,omitempty"`
+	ToxicsRemoved []string `json:"toxics_removed,omitempty"`
+	// HTTP toxics are replaced as a whole when they change.
+	HTTPToxicsUpdated bool `json:"http_toxics_updated,omitempty"`
+}
+
+func (d RouteDiff) empty() bool {
+	return d.Enabled == nil && len(d.ToxicsAdded) == 0 && len(d.ToxicsUpdated) == 0 && len(d.ToxicsRemoved) == 0 && !d.HTTPToxicsUpdated
+}
+
+// ReloadRoutes reconciles the live routes against those returned by load. Routes
+// that were loaded from the config before and are no longer in it are removed,
+// routes added through the API are left alone.
+func (s *ShrikeServer) ReloadRoutes(load func() ([]RouteConfig, error)) ConfigStatus {
+	s.changeMu.Lock()
+	defer s.changeMu.Unlock()
+
+	status := ConfigStatus{Time: time.Now()}
+	routes, err := load()
+	if err == nil {
+		status.Diff, err = s.reconcile(routes)
+		s.changed()
+	}
+	status.Success = err == nil
+	if err != nil {
+		status.Error = err.Error()
+		log.WithField("err", err).Error("Error reloading route config")
+	} else {
+		log.WithFields(log.Fields{
+			"added":   len(status.Diff.Added),
+			"removed": len(status.Diff.Removed),
+			"changed": len(status.Diff.Changed),
+		}).Info("Reloaded route config")
+	}
+
+	s.mu.Lock()
+	s.configStatus = &status
+	s.mu.Unlock()
+	return status
+}
+
+// reconcile the live routes with routes, stopping at the first error.
+func (s *ShrikeServer) reconcile(routes []RouteConfig) (ConfigDiff, error) {
+	diff := ConfigDiff{Added: []string{}, Removed: []string{}, Changed: []RouteDiff{}}
+	wanted := map[string]bool{}
+	for _, rc := range routes {
+		wanted[s.routeName(rc.Route)] = true
+	}
+
+	s.mu.RLock()
+	previous := s.configRoutes
+	s.mu.RUnlock()
+	removed := []string{}
+	for name := range previous {
+		if !wanted[name] {
+			removed = append(removed, name)
+		}
+	}
+	sort.Strings(removed)
+	for _, name := range removed {
+		// A proxy that is already gone from Toxiproxy only needs removing from the store.
+		if proxy, err := s.client.Proxy(name); err == nil {
+			if err := proxy.Delete(); err != nil {
+				return diff, err
+			}
+		}
+		if r := s.ProxyStore.Get(name); r != nil {
+			s.removeRoute(r.Proxy)
+		}
+		diff.Removed = append(diff.Removed, name)
+	}
+
+	for _, rc := range routes {
+		name := s.routeName(rc.Route)
+		existed := s.ProxyStore.Get(name) != nil
+		d, err := s.applyRouteConfig(rc)
+		if err != nil {
+			return diff, err
+		}
+		if !existed {
+			diff.Added = append(diff.Added, name)
+		} else if !d.empty() {
+			diff.Changed = append(diff.Changed, d)
+		}
+	}
+
+	s.mu.Lock()
+	s.configRoutes = wanted
+	s.mu.Unlock()
+	return diff, nil
+}
+
+// GetConfigStatus returns the result of the last route config reload.
+func (s *ShrikeServer) GetConfigStatus(w http.ResponseWriter, req *http.Request) {
+	s.mu.RLock()
+	status := s.configStatus
+	s.mu.RUnlock()
+	if status == nil {
+		RespondWithError(w, http.StatusNotFound, JSONError{
+			Status:  "No Reload",
+			Message: "The route config has not been reloaded.",
+		})
+		return
+	}
+
+	b, _ := json.Marshal(status)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
@@ -0,0 +1,93 @@
+package api
+
+import (
+	"errors"
+	"net/http/httptest"
+
+	toxy "github.com/Shopify/toxiproxy/client"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/richardbolt/shrike/client"
+)
+
+var _ = Describe("Reloading the route config", func() {
+	var (
+		upstream *httptest.Server
+		cfg      Config
+		s        *ShrikeServer
+		api      *client.Client
+	)
+
+	latency := func(ms int) toxy.Toxics {
+		return toxy.Toxics{{Name: "slow", Type: "latency", Toxicity: 1, Attributes: toxy.Attributes{"latency": ms}}}
+	}
+
+	BeforeEach(func() {
+		upstream = httptest.NewServer(nil)
+		cfg = testConfig(upstream.URL)
+		cfg.Routes = []RouteConfig{
+			{Route: Route{Prefix: "/orders"}, Enabled: true, Toxics: latency(100)},
+			{Route: Route{Prefix: "/users"}, Enabled: true},
+		}
+		s = startServer(cfg)
+		api = apiClient(cfg)
+		_, err := api.CreateRoute(client.Route{Prefix: "/api-only"})
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		s.Close()
+		upstream.Close()
+	})
+
+	It("adds, changes and removes the config's routes, keeping those added through the api", func() {
+		_, err := api.ConfigStatus()
+		Expect(client.IsNotFound(err)).To(BeTrue())
+
+		status := s.ReloadRoutes(func() ([]RouteConfig, error) {
+			return []RouteConfig{
+				{Route: Route{Prefix: "/orders"}, Enabled: false, Toxics: latency(200)},
+				{Route: Route{Prefix: "/payments"}, Enabled: true},
+			}, nil
+		})
+		Expect(status.Success).To(BeTrue())
+		Expect(status.Diff.Added).To(Equal([]string{"__payments"}))
+		Expect(status.Diff.Removed).To(Equal([]string{"__users"}))
+		Expect(status.Diff.Changed).To(HaveLen(1))
+		Expect(status.Diff.Changed[0].Name).To(Equal("__orders"))
+		Expect(*status.Diff.Changed[0].Enabled).To(BeFalse())
+		Expect(status.Diff.Changed[0].ToxicsUpdated).To(Equal([]string{"slow"}))
+
+		routes, err := api.Routes()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(routes).To(HaveLen(3))
+		Expect(routes).To(HaveKey("__orders"))
+		Expect(routes).To(HaveKey("__payments"))
+		Expect(routes).To(HaveKey("__api-only"))
+		Expect(routes["__orders"].Proxy.Enabled).To(BeFalse())
+		toxics, err := routes["__orders"].Toxics()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(toxics).To(HaveLen(1))
+		Expect(toxics[0].Attributes["latency"]).To(BeNumerically("==", 200))
+
+		reported, err := api.ConfigStatus()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(reported.Success).To(BeTrue())
+		Expect(reported.Diff.Added).To(Equal([]string{"__payments"}))
+		Expect(reported.Diff.Removed).To(Equal([]string{"__users"}))
+	})
+
+	It("reports a config that fails to load, leaving the routes", func() {
+		status := s.ReloadRoutes(func() ([]RouteConfig, error) {
+			return nil, errors.New("bad yaml")
+		})
+		Expect(status.Success).To(BeFalse())
+
+		reported, err := api.ConfigStatus()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(reported.Success).To(BeFalse())
+		Expect(reported.Error).To(Equal("bad yaml"))
+		Expect(api.Routes()).To(HaveLen(3))
+	})
+})
@@ -0,0 +1,676 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	toxy "github.com/Shopify/toxiproxy/client"
+	"github.com/go-chi/chi"
+	"github.com/richardbolt/shrike/store"
+	log "github.com/sirupsen/logrus"
+)
+
+// RouteConfig is the full configuration of a route: its settings, whether its
+// proxy is enabled and the toxics on it.
+type RouteConfig struct {
+	Route      `yaml:",inline"`
+	Enabled    bool        `json:"enabled"`
+	Toxics     toxy.Toxics `json:"toxics"`
+	HTTPToxics HTTPToxics  `json:"http_toxics,omitempty" yaml:"http_toxics"`
+	// ToxicExpiries holds when each timed toxic is removed, by toxic name.
+	ToxicExpiries map[string]time.Time `json:"toxic_expiries,omitempty" yaml:"toxic_expiries"`
+}
+
+// addRoute creates the Toxiproxy proxy for the route, or adopts an existing
+// one by the same name, and adds it to the store.
+func (s *ShrikeServer) addRoute(r Route) (*toxy.Proxy, error) {
+	if err := store.ValidatePattern(r.Match, r.Prefix); err != nil {
+		return nil, err
+	}
+	upstream, err := s.routeUpstream(r)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig, err := r.TLS.Config(upstream)
+	if err != nil {
+		return nil, err
+	}
+	named := r.Name != ""
+	r.Name = s.routeName(r)
+	proxyName := r.Name
+	proxy, err := s.client.Proxy(proxyName)
+	if legacy := store.LegacyProxyNameFrom(s.cfg.ToxyPathSeparator, r.Prefix); err != nil && !named && legacy != proxyName {
+		// A proxy made before prefixes were escaped in names is moved to the
+		// new name rather than left behind.
+		if old, lerr := s.client.Proxy(legacy); lerr == nil {
+			if proxy, err = s.renameProxy(old, proxyName); err != nil {
+				return nil, err
+			}
+		}
+	}
+	created := err != nil
+	if !created {
+		if port, err := portOf(proxy.Listen); err == nil {
+			s.ports.Reserve(proxyName, port)
+		}
+		// The route may have been re-added with another upstream.
+		if proxy.Upstream != upstreamAddr(upstream) {
+			proxy.Upstream = upstreamAddr(upstream)
+			if err := proxy.Save(); err != nil {
+				return nil, err
+			}
+		}
+	} else if proxy, err = s.createProxy(proxyName, upstreamAddr(upstream)); err != nil {
+		return nil, err
+	}
+
+	// TLS to an https upstream is re-originated on the far side of Toxiproxy.
+	dial := s.dialAddr(proxy.Listen)
+	if upstream.Scheme == "https" {
+		s.transport.Set(dial, tlsConfig)
+	} else {
+		s.transport.Remove(dial)
+	}
+	err = s.ProxyStore.Add(&store.Route{
+		Name:     r.Name,
+		Prefix:   r.Prefix,
+		Match:    r.Match,
+		Host:     r.Host,
+		Methods:  r.Methods,
+		Headers:  r.Headers,
+		Query:    r.Query,
+		Priority: r.Priority,
+		Upstream: *upstream,
+		Proxy:    proxy,
+		Dial:     dial,
+	})
+	if err != nil {
+		// Don't leave a proxy or port behind for a route that wasn't added.
+		if created {
+			s.transport.Remove(dial)
+			proxy.Delete()
+			s.ports.Release(proxyName)
+		}
+		return nil, err
+	}
+	s.mu.Lock()
+	s.routes[r.Name] = r
+	s.owned[r.Name] = r
+	s.mu.Unlock()
+	return proxy, nil
+}
+
+// renameProxy replaces the proxy with one by the name on the same listen
+// address, with the same upstream, toxics and enabled state.
+func (s *ShrikeServer) renameProxy(old *toxy.Proxy, name string) (*toxy.Proxy, error) {
+	if err := old.Delete(); err != nil {
+		return nil, err
+	}
+	proxy, err := s.client.CreateProxy(name, old.Listen, old.Upstream)
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range old.ActiveToxics {
+		if _, err := proxy.AddToxic(t.Name, t.Type, t.Stream, t.Toxicity, t.Attributes); err != nil {
+			return nil, err
+		}
+	}
+	if !old.Enabled {
+		if err := proxy.Disable(); err != nil {
+			return nil, err
+		}
+	}
+	log.WithFields(log.Fields{
+		"from": old.Name,
+		"to":   name,
+	}).Info("Renamed a proxy named before prefixes were escaped")
+	return s.client.Proxy(name)
+}
+
+// maxPortTries is how many ports a proxy is tried on before giving up when they
+// are in use by something else.
+const maxPortTries = 10
+
+// createProxy creates the Toxiproxy proxy on a port from the range, or one
+// picked by the OS. A port something else is listening on is marked unusable
+// and the next free port is tried.
+func (s *ShrikeServer) createProxy(name, upstream string) (*toxy.Proxy, error) {
+	for tries := 1; ; tries++ {
+		port := 0
+		if !s.cfg.ToxyEphemeralPorts {
+			var err error
+			if port, err = s.ports.Allocate(name); err != nil {
+				return nil, err
+			}
+		}
+		proxy, err := s.client.CreateProxy(
+			name,
+			net.JoinHostPort(s.cfg.ToxyListenHost, strconv.Itoa(port)),
+			upstream,
+		)
+		if err == nil {
+			return proxy, nil
+		}
+		if port == 0 || !portInUse(err) || tries == maxPortTries {
+			s.ports.Release(name)
+			return nil, err
+		}
+		log.WithFields(log.Fields{
+			"proxy": name,
+			"port":  port,
+			"err":   err,
+		}).Warn("Toxiproxy port is in use, trying another")
+		s.ports.Unusable(name)
+	}
+}
+
+// portInUse reports whether Toxiproxy failed to create a proxy because it
+// couldn't listen on the port.
+func portInUse(err error) bool {
+	return strings.Contains(err.Error(), "bind: ")
+}
+
+// removeRoute from the store. The Toxiproxy proxy is left to the caller.
+func (s *ShrikeServer) removeRoute(proxy *toxy.Proxy) {
+	s.ProxyStore.Delete(proxy.Name)
+	s.transport.Remove(s.dialAddr(proxy.Listen))
+	s.ports.Release(proxy.Name)
+	s.cancelExpiries(proxy.Name)
+	s.mu.Lock()
+	delete(s.routes, proxy.Name)
+	delete(s.owned, proxy.Name)
+	delete(s.routeHTTPToxics, proxy.Name)
+	s.mu.Unlock()
+}
+
+// own records the route as Shrike's before it is loaded, so its proxy can be
+// adopted by drift repair should the route fail to load.
+func (s *ShrikeServer) own(r Route) {
+	s.mu.Lock()
+	s.owned[s.routeName(r)] = r
+	s.mu.Unlock()
+}
+
+// ownedRoute returns the route of the proxy if it's Shrike's.
+func (s *ShrikeServer) ownedRoute(name string) (Route, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	r, ok := s.owned[name]
+	return r, ok
+}
+
+// routeUpstream is the route's own upstream, or the default for its host, or
+// the global upstream.
+func (s *ShrikeServer) routeUpstream(r Route) (*url.URL, error) {
+	if r.Upstream != "" {
+		return parseUpstream(r.Upstream)
+	}
+	if u, ok := s.hostUpstreams[strings.ToLower(r.Host)]; ok && r.Host != "" {
+		return &u, nil
+	}
+	return s.upstream, nil
+}
+
+// parseUpstream parses an http or https upstream URL.
+func parseUpstream(upstream string) (*url.URL, error) {
+	u, err := url.Parse(upstream)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("upstream %q must be an http or https URL", upstream)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("upstream %q has no host", upstream)
+	}
+	return u, nil
+}
+
+// routeName is the route's name, which defaults to the proxy name for its prefix.
+func (s *ShrikeServer) routeName(r Route) string {
+	if r.Name != "" {
+		return r.Name
+	}
+	return store.ProxyNameFrom(s.cfg.ToxyPathSeparator, r.Prefix)
+}
+
+// routeRef returns the route name from the request's route URL param, which is
+// either the route's name or its URL-encoded path prefix, such as %2Forders%2Fv1.
+func (s *ShrikeServer) routeRef(req *http.Request) string {
+	return s.routeByRef(pathParam(req, "route"))
+}
+
+// routeByRef returns the name of the route named ref or, when ref is a path, of
+// the route with that prefix. When several routes share the prefix it's the one
+// named after the prefix; the others must be referred to by name.
+func (s *ShrikeServer) routeByRef(ref string) string {
+	if !strings.HasPrefix(ref, "/") || s.ProxyStore.Get(ref) != nil {
+		return ref
+	}
+	found := []string{}
+	for name, r := range s.ProxyStore.ToMap() {
+		if r.Prefix == ref {
+			found = append(found, name)
+		}
+	}
+	if len(found) == 1 {
+		return found[0]
+	}
+	return s.routeName(Route{Prefix: ref})
+}
+
+// pathParam returns the URL param, unescaped when chi matched on the escaped
+// path because it had encoded slashes in it.
+func pathParam(req *http.Request, key string) string {
+	v := chi.URLParam(req, key)
+	if req.URL.RawPath == "" {
+		return v
+	}
+	if u, err := url.PathUnescape(v); err == nil {
+		return u
+	}
+	return v
+}
+
+// dialAddr is the address to reach a proxy listening on listen at: the same
+// port on the Toxiproxy listen host.
+func (s *ShrikeServer) dialAddr(listen string) string {
+	port, err := portOf(listen)
+	if err != nil {
+		return listen
+	}
+	return net.JoinHostPort(s.cfg.ToxyListenHost, strconv.Itoa(port))
+}
+
+// portOf the listen address.
+func portOf(listen string) (int, error) {
+	_, port, err := net.SplitHostPort(listen)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(port)
+}
+
+// route returns the settings the route by name was added with.
+func (s *ShrikeServer) route(name string) Route {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if r, ok := s.routes[name]; ok {
+		return r
+	}
+	r := Route{Name: name}
+	if sr := s.ProxyStore.Get(name); sr != nil {
+		r.Prefix = sr.Prefix
+	}
+	return r
+}
+
+// applyRouteConfig adds the route and brings its enabled state and toxics in
+// line with the configuration, adding, updating and removing toxics as needed.
+// The changes made are returned.
+func (s *ShrikeServer) applyRouteConfig(rc RouteConfig) (RouteDiff, error) {
+	name := s.routeName(rc.Route)
+	diff := RouteDiff{Name: name}
+	proxy, err := s.addRoute(rc.Route)
+	if err != nil {
+		return diff, err
+	}
+	if proxy.Enabled != rc.Enabled {
+		if rc.Enabled {
+			err = proxy.Enable()
+		} else {
+			err = proxy.Disable()
+		}
+		if err != nil {
+			return diff, err
+		}
+		diff.Enabled = &rc.Enabled
+	}
+
+	existing := map[string]toxy.Toxic{}
+	for _, t := range proxy.ActiveToxics {
+		existing[t.Name] = t
+	}
+	for _, t := range rc.Toxics {
+		name := toxicName(t)
+		current, ok := existing[name]
+		delete(existing, name)
+		switch {
+		case !ok:
+			_, err = proxy.AddToxic(t.Name, t.Type, t.Stream, t.Toxicity, t.Attributes)
+			diff.ToxicsAdded = append(diff.ToxicsAdded, name)
+		case current.Type != t.Type || current.Stream != streamOf(t):
+			// Toxiproxy can't change a toxic's type or stream so it is replaced.
+			if err = proxy.RemoveToxic(name); err == nil {
+				s.cancelExpiry(proxy.Name, name)
+				_, err = proxy.AddToxic(t.Name, t.Type, t.Stream, t.Toxicity, t.Attributes)
+			}
+			diff.ToxicsUpdated = append(diff.ToxicsUpdated, name)
+		case !sameToxic(current, t):
+			_, err = proxy.UpdateToxic(name, t.Toxicity, t.Attributes)
+			diff.ToxicsUpdated = append(diff.ToxicsUpdated, name)
+		}
+		if err != nil {
+			return diff, err
+		}
+	}
+	for name := range existing {
+		if err := proxy.RemoveToxic(name); err != nil {
+			return diff, err
+		}
+		s.cancelExpiry(proxy.Name, name)
+		diff.ToxicsRemoved = append(diff.ToxicsRemoved, name)
+	}
+	for toxic, at := range rc.ToxicExpiries {
+		s.expireToxic(proxy.Name, toxic, at)
+	}
+
+	httpToxics := HTTPToxics{}
+	for _, t := range rc.HTTPToxics {
+		if t.Name == "" {
+			t.Name = t.Type
+		}
+		httpToxics = append(httpToxics, t)
+	}
+	if current := s.httpToxics(name); !reflect.DeepEqual(current, httpToxics) && (len(current) > 0 || len(httpToxics) > 0) {
+		s.setHTTPToxics(name, httpToxics)
+		diff.HTTPToxicsUpdated = true
+	}
+	return diff, nil
+}
+
+// loadRoutes applies the route configurations once Toxiproxy is up. Every route
+// is tried and the first that fails is returned as an error.
+func (s *ShrikeServer) loadRoutes(routes []RouteConfig, source string) error {
+	if len(routes) == 0 {
+		return nil
+	}
+	if err := s.waitForToxiproxy(10 * time.Second); err != nil {
+		return fmt.Errorf("Toxiproxy is not available to load the %s routes into: %s", source, err)
+	}
+	var failed error
+	for _, rc := range routes {
+		s.own(rc.Route)
+		if _, err := s.applyRouteConfig(rc); err != nil {
+			log.WithFields(log.Fields{
+				"source": source,
+				"Route":  rc.Prefix,
+				"err":    err,
+			}).Error("Error loading route")
+			if failed == nil {
+				failed = fmt.Errorf("%s route %q: %s", source, rc.Prefix, err)
+			}
+		}
+	}
+	if failed != nil {
+		return failed
+	}
+	log.WithFields(log.Fields{
+		"source": source,
+		"routes": len(routes),
+	}).Info("Loaded routes")
+	return nil
+}
+
+// Validate the route.
+func (r Route) Validate() error {
+	if err := store.ValidatePattern(r.Match, r.Prefix); err != nil {
+		return err
+	}
+	if r.Match == store.MatchRegex {
+		if r.Name == "" {
+			return errors.New("regex routes must be named")
+		}
+	} else if !strings.HasPrefix(r.Prefix, "/") {
+		return errors.New("prefix must be a path starting with /")
+	}
+	if strings.Contains(r.Name, "/") {
+		return errors.New("name must not contain /")
+	}
+	if strings.Contains(r.Host, "/") {
+		return errors.New("host must be a host name, not a URL")
+	}
+	if r.Upstream != "" {
+		if _, err := parseUpstream(r.Upstream); err != nil {
+			return err
+		}
+	}
+	for _, m := range r.Methods {
+		if m == "" {
+			return errors.New("methods must not be empty")
+		}
+	}
+	for k := range r.Headers {
+		if k == "" {
+			return errors.New("header names must not be empty")
+		}
+	}
+	for k := range r.Query {
+		if k == "" {
+			return errors.New("query parameter names must not be empty")
+		}
+	}
+	if _, err := r.TLS.Config(&url.URL{}); err != nil {
+		return fmt.Errorf("tls: %s", err)
+	}
+	return nil
+}
+
+// Validate the route configuration.
+func (rc RouteConfig) Validate() error {
+	if err := rc.Route.Validate(); err != nil {
+		return err
+	}
+	names := map[string]bool{}
+	for i, t := range rc.Toxics {
+		if t.Type == "" {
+			return fmt.Errorf("toxic %d has no type", i)
+		}
+		if !toxicTypes[t.Type] {
+			return fmt.Errorf("toxic %q type %q is not a Toxiproxy toxic type", toxicName(t), t.Type)
+		}
+		if t.Stream != "" && t.Stream != "upstream" && t.Stream != "downstream" {
+			return fmt.Errorf("toxic %q stream must be upstream or downstream", toxicName(t))
+		}
+		if t.Toxicity < 0 || t.Toxicity > 1 {
+			return fmt.Errorf("toxic %q toxicity must be between 0 and 1", toxicName(t))
+		}
+		if names[toxicName(t)] {
+			return fmt.Errorf("toxic %q is defined more than once", toxicName(t))
+		}
+		names[toxicName(t)] = true
+	}
+	for name := range rc.ToxicExpiries {
+		if !names[name] {
+			return fmt.Errorf("toxic_expiries has no toxic %q", name)
+		}
+	}
+	httpNames := map[string]bool{}
+	for _, t := range rc.HTTPToxics {
+		if t.Name == "" {
+			t.Name = t.Type
+		}
+		if err := t.Validate(); err != nil {
+			return err
+		}
+		if httpNames[t.Name] {
+			return fmt.Errorf("http toxic %q is defined more than once", t.Name)
+		}
+		httpNames[t.Name] = true
+	}
+	return nil
+}
+
+// ValidateRoutes validates each route and that no route is defined twice.
+// Routes sharing a prefix must be named.
+func ValidateRoutes(routes []RouteConfig) error {
+	seen := map[string]bool{}
+	for i, r := range routes {
+		if err := r.Validate(); err != nil {
+			return fmt.Errorf("route %d %q: %s", i, r.Prefix, err)
+		}
+		key := r.Name
+		if key == "" {
+			key = r.Prefix
+		}
+		if seen[key] {
+			return fmt.Errorf("route %d %q: route is defined more than once, routes sharing a prefix must be named", i, r.Prefix)
+		}
+		seen[key] = true
+	}
+	return nil
+}
+
+// UnmarshalJSON defaults routes to enabled and toxics to a toxicity of 1 when
+// they aren't given.
+func (rc *RouteConfig) UnmarshalJSON(b []byte) error {
+	type plain RouteConfig
+	p := plain{Enabled: true}
+	if err := json.Unmarshal(b, &p); err != nil {
+		return err
+	}
+	toxics := struct {
+		Toxics []configToxic `json:"toxics"`
+	}{}
+	if err := json.Unmarshal(b, &toxics); err != nil {
+		return err
+	}
+	*rc = RouteConfig(p)
+	rc.setToxics(toxics.Toxics)
+	return nil
+}
+
+// UnmarshalYAML defaults routes to enabled and toxics to a toxicity of 1 when
+// they aren't given.
+func (rc *RouteConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain RouteConfig
+	p := plain{Enabled: true}
+	if err := unmarshal(&p); err != nil {
+		return err
+	}
+	toxics := struct {
+		Toxics []configToxic `yaml:"toxics"`
+	}{}
+	if err := unmarshal(&toxics); err != nil {
+		return err
+	}
+	*rc = RouteConfig(p)
+	rc.setToxics(toxics.Toxics)
+	return nil
+}
+
+func (rc *RouteConfig) setToxics(toxics []configToxic) {
+	if toxics == nil {
+		return
+	}
+	rc.Toxics = toxy.Toxics{}
+	for _, t := range toxics {
+		rc.Toxics = append(rc.Toxics, toxy.Toxic(t))
+	}
+}
+
+// toxicTypes are the types of toxic Toxiproxy has.
+var toxicTypes = map[string]bool{
+	"latency":    true,
+	"bandwidth":  true,
+	"slow_close": true,
+	"timeout":    true,
+	"slicer":     true,
+	"limit_data": true,
+}
+
+// configToxic is a toxic in a route config. Its toxicity defaults to 1 when it
+// isn't given, as Toxiproxy does, rather than 0 which would never apply it.
+type configToxic toxy.Toxic
+
+func (t *configToxic) UnmarshalJSON(b []byte) error {
+	type plain configToxic
+	p := plain{Toxicity: 1}
+	if err := json.Unmarshal(b, &p); err != nil {
+		return err
+	}
+	*t = configToxic(p)
+	return nil
+}
+
+func (t *configToxic) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain configToxic
+	p := plain{Toxicity: 1}
+	if err := unmarshal(&p); err != nil {
+		return err
+	}
+	*t = configToxic(p)
+	return nil
+}
+
+// toxicName is the name Toxiproxy gives the toxic: its own or <type>_<stream>.
+func toxicName(t toxy.Toxic) string {
+	if t.Name != "" {
+		return t.Name
+	}
+	return t.Type + "_" + streamOf(t)
+}
+
+// streamOf the toxic, which Toxiproxy defaults to downstream.
+func streamOf(t toxy.Toxic) string {
+	if t.Stream == "" {
+		return "downstream"
+	}
+	return t.Stream
+}
+
+// sameToxic reports whether the current toxic already has the toxicity and
+// attributes wanted. Attributes not given are left to Toxiproxy's defaults.
+func sameToxic(current, want toxy.Toxic) bool {
+	if current.Toxicity != want.Toxicity {
+		return false
+	}
+	for k, v := range want.Attributes {
+		// Compare as JSON so numbers decoded from YAML and JSON are equal.
+		a, _ := json.Marshal(current.Attributes[k])
+		b, _ := json.Marshal(v)
+		if string(a) != string(b) {
+			return false
+		}
+	}
+	return true
+}
+
+// routeConfigs returns the configuration of every route in the store, sorted by prefix and name.
+func (s *ShrikeServer) routeConfigs() ([]RouteConfig, error) {
+	proxies, err := s.client.Proxies()
+	if err != nil {
+		return nil, err
+	}
+
+	configs := []RouteConfig{}
+	for k, v := range s.ProxyStore.ToMap() {
+		proxy := proxies[v.Proxy.Name]
+		if proxy == nil {
+			continue
+		}
+		configs = append(configs, RouteConfig{
+			Route:         s.route(k),
+			Enabled:       proxy.Enabled,
+			Toxics:        proxy.ActiveToxics,
+			HTTPToxics:    s.httpToxics(k),
+			ToxicExpiries: s.toxicExpiries(k),
+		})
+	}
+	sort.Slice(configs, func(i, j int) bool {
+		if configs[i].Prefix != configs[j].Prefix {
+			return configs[i].Prefix < configs[j].Prefix
+		}
+		return configs[i].Name < configs[j].Name
+	})
+	return configs, nil
+}
@@ -0,0 +1,163 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+
+	toxy "github.com/Shopify/toxiproxy/client"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	yaml "gopkg.in/yaml.v2"
+
+	"github.com/richardbolt/shrike/client"
+)
+
+var _ = Describe("RouteConfig", func() {
+	It("defaults toxicity to 1 when it isn't given", func() {
+		routes := []RouteConfig{}
+		Expect(yaml.Unmarshal([]byte(`
+- prefix: /orders
+  toxics:
+    - type: latency
+      attributes:
+        latency: 500
+    - type: timeout
+      toxicity: 0
+`), &routes)).To(Succeed())
+		Expect(routes[0].Enabled).To(BeTrue())
+		Expect(routes[0].Toxics).To(HaveLen(2))
+		Expect(routes[0].Toxics[0].Toxicity).To(BeEquivalentTo(1))
+		Expect(routes[0].Toxics[0].Attributes).To(HaveKeyWithValue("latency", 500))
+		Expect(routes[0].Toxics[1].Toxicity).To(BeEquivalentTo(0))
+
+		rc := RouteConfig{}
+		Expect(json.Unmarshal([]byte(`{"prefix": "/orders", "toxics": [{"type": "latency"}]}`), &rc)).To(Succeed())
+		Expect(rc.Toxics[0].Toxicity).To(BeEquivalentTo(1))
+	})
+
+	It("rejects toxic types Toxiproxy doesn't have", func() {
+		rc := RouteConfig{Route: Route{Prefix: "/orders"}, Toxics: toxy.Toxics{{Type: "latency"}}}
+		Expect(rc.Validate()).To(Succeed())
+		rc.Toxics[0].Type = "latnecy"
+		Expect(rc.Validate()).To(MatchError(ContainSubstring(`type "latnecy"`)))
+	})
+})
+
+var _ = Describe("Start", func() {
+	It("fails when a config file route can't be applied to Toxiproxy", func() {
+		upstream := httptest.NewServer(nil)
+		defer upstream.Close()
+		c := testConfig(upstream.URL)
+		c.Routes = []RouteConfig{{
+			Route:   Route{Prefix: "/orders"},
+			Enabled: true,
+			Toxics:  toxy.Toxics{{Type: "latency", Toxicity: 1, Attributes: toxy.Attributes{"latency": "slow"}}},
+		}}
+		s, err := New(c)
+		Expect(err).NotTo(HaveOccurred())
+		defer s.Close()
+		Expect(s.Start(context.Background())).To(MatchError(ContainSubstring(`config file route "/orders"`)))
+	})
+})
+
+var _ = Describe("GET /routes", func() {
+	It("keys routes by prefix unless they were named", func() {
+		upstream := httptest.NewServer(nil)
+		defer upstream.Close()
+		cfg := testConfig(upstream.URL)
+		s := startServer(cfg)
+		defer s.Close()
+		c := apiClient(cfg)
+		_, err := c.CreateRoute(client.Route{Prefix: "/orders"})
+		Expect(err).NotTo(HaveOccurred())
+		_, err = c.CreateRoute(client.Route{Name: "order_creates", Prefix: "/orders", Methods: []string{"POST"}})
+		Expect(err).NotTo(HaveOccurred())
+
+		resp, err := http.Get(fmt.Sprintf("http://127.0.0.1:%d/routes", cfg.APIPort))
+		Expect(err).NotTo(HaveOccurred())
+		defer resp.Body.Close()
+		routes := map[string]RouteWithProxy{}
+		Expect(json.NewDecoder(resp.Body).Decode(&routes)).To(Succeed())
+		Expect(routes).To(HaveLen(2))
+		Expect(routes).To(HaveKey("/orders"))
+		Expect(routes["/orders"].Route.Name).To(Equal("__orders"))
+		Expect(routes).To(HaveKey("order_creates"))
+
+		byName, err := c.Routes()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(byName).To(HaveKey("__orders"))
+		Expect(byName).To(HaveKey("order_creates"))
+	})
+})
+
+var _ = Describe("addRoute", func() {
+	It("leaves nothing behind for a route that can't be added", func() {
+		upstream := httptest.NewServer(nil)
+		defer upstream.Close()
+		s := startServer(testConfig(upstream.URL))
+		defer s.Close()
+
+		_, err := s.addRoute(Route{Name: "bad", Prefix: "^/users/(", Match: "regex"})
+		Expect(err).To(HaveOccurred())
+		proxies, err := s.client.Proxies()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(proxies).To(BeEmpty())
+		Expect(s.ProxyStore.ToMap()).To(BeEmpty())
+		Expect(s.route("bad").Prefix).To(BeEmpty())
+	})
+})
+
+var _ = Describe("Updating a toxic", func() {
+	It("keeps the toxicity when the update doesn't give one", func() {
+		upstream := httptest.NewServer(nil)
+		defer upstream.Close()
+		cfg := testConfig(upstream.URL)
+		s := startServer(cfg)
+		defer s.Close()
+
+		route, err := apiClient(cfg).CreateRoute(client.Route{Prefix: "/orders"})
+		Expect(err).NotTo(HaveOccurred())
+		_, err = route.AddToxic("slow", "latency", "", 0.5, toxy.Attributes{"latency": 100})
+		Expect(err).NotTo(HaveOccurred())
+
+		t, err := route.UpdateToxicAttributes("slow", toxy.Attributes{"latency": 200})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(t.Toxicity).To(BeNumerically("==", 0.5))
+		Expect(t.Attributes["latency"]).To(BeNumerically("==", 200))
+
+		t, err = route.UpdateToxic("slow", 0.25, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(t.Toxicity).To(BeNumerically("==", 0.25))
+		Expect(t.Attributes["latency"]).To(BeNumerically("==", 200))
+	})
+})
+
+var _ = Describe("Proxies named before prefixes were escaped", func() {
+	It("are moved to the new name with their listen address, toxics and state", func() {
+		upstream := httptest.NewServer(nil)
+		defer upstream.Close()
+		cfg := testConfig(upstream.URL)
+		s := startServer(cfg)
+		defer s.Close()
+
+		old, err := s.client.CreateProxy("__my_service", "127.0.0.1:0", upstreamAddr(s.upstream))
+		Expect(err).NotTo(HaveOccurred())
+		_, err = old.AddToxic("slow", "latency", "downstream", 1, toxy.Attributes{"latency": 100})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(old.Disable()).To(Succeed())
+
+		route, err := apiClient(cfg).CreateRoute(client.Route{Prefix: "/my_service"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(route.Name).To(Equal("__my~5Fservice"))
+		Expect(route.Proxy.Listen).To(Equal(old.Listen))
+		Expect(route.Proxy.Enabled).To(BeFalse())
+		Expect(route.Toxics()).To(HaveLen(1))
+
+		proxies, err := s.client.Proxies()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(proxies).NotTo(HaveKey("__my_service"))
+	})
+})
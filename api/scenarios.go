@@ -0,0 +1,452 @@
+package api
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi"
+	log "github.com/sirupsen/logrus"
+)
+
+// Scenario step actions.
+const (
+	ActionAddToxic        = "add_toxic"
+	ActionRemoveToxic     = "remove_toxic"
+	ActionAddHTTPToxic    = "add_http_toxic"
+	ActionRemoveHTTPToxic = "remove_http_toxic"
+	ActionEnable          = "enable"
+	ActionDisable         = "disable"
+	ActionReset           = "reset"
+)
+
+// Scenario and step states.
+const (
+	StatePending   = "pending"
+	StateRunning   = "running"
+	StateCompleted = "completed"
+	StateFailed    = "failed"
+	StateCancelled = "cancelled"
+)
+
+// maxFinishedScenarios is how many finished scenarios are kept for reporting.
+const maxFinishedScenarios = 100
+
+// Scenario is a timeline of changes to make to the routes.
+type Scenario struct {
+	Name  string         `json:"name,omitempty"`
+	Steps []ScenarioStep `json:"steps"`
+}
+
+// ScenarioStep is a change made At an offset, such as "60s", from the start of
+// the scenario. Route is the route's name or prefix. Toxic is the toxic to add
+// or, by name, remove and HTTPToxic likewise for HTTP toxics.
+type ScenarioStep struct {
+	At        string      `json:"at"`
+	Action    string      `json:"action"`
+	Route     string      `json:"route,omitempty"`
+	Toxic     *TimedToxic `json:"toxic,omitempty"`
+	HTTPToxic *HTTPToxic  `json:"http_toxic,omitempty"`
+}
+
+// ScenarioStatus is the progress of a scenario run.
+type ScenarioStatus struct {
+	ID         string       `json:"id"`
+	Name       string       `json:"name,omitempty"`
+	State      string       `json:"state"`
+	StartedAt  time.Time    `json:"started_at"`
+	FinishedAt *time.Time   `json:"finished_at,omitempty"`
+	Steps      []StepStatus `json:"steps"`
+}
+
+// StepStatus is the progress of a step in a scenario run.
+type StepStatus struct {
+	ScenarioStep
+	State string     `json:"state"`
+	RanAt *time.Time `json:"ran_at,omitempty"`
+	Error string     `json:"error,omitempty"`
+}
+
+// scenarioRun is a running or finished scenario.
+type scenarioRun struct {
+	status    ScenarioStatus
+	offset    []time.Duration
+	cancel    chan struct{}
+	cancelled bool
+}
+
+// Validate the scenario's steps.
+func (sc Scenario) Validate() error {
+	if len(sc.Steps) == 0 {
+		return errors.New("a scenario needs at least one step")
+	}
+	for i, step := range sc.Steps {
+		if err := step.Validate(); err != nil {
+			return fmt.Errorf("step %d: %s", i, err)
+		}
+	}
+	return nil
+}
+
+// Validate the step has what its action needs.
+func (st ScenarioStep) Validate() error {
+	at, err := time.ParseDuration(st.At)
+	if err != nil {
+		return fmt.Errorf("at: %s", err)
+	}
+	if at < 0 {
+		return errors.New("at must not be negative")
+	}
+	switch st.Action {
+	case ActionReset:
+		return nil
+	case ActionEnable, ActionDisable:
+	case ActionAddToxic:
+		if st.Toxic == nil || st.Toxic.Type == "" {
+			return errors.New("add_toxic needs a toxic with a type")
+		}
+		if _, err := st.Toxic.expiresAt(time.Now()); err != nil {
+			return err
+		}
+	case ActionRemoveToxic:
+		if st.Toxic == nil || st.Toxic.Name == "" {
+			return errors.New("remove_toxic needs a toxic with a name")
+		}
+	case ActionAddHTTPToxic:
+		if st.HTTPToxic == nil {
+			return errors.New("add_http_toxic needs an http_toxic")
+		}
+		t := *st.HTTPToxic
+		if t.Name == "" {
+			t.Name = t.Type
+		}
+		if err := t.Validate(); err != nil {
+			return err
+		}
+	case ActionRemoveHTTPToxic:
+		if st.HTTPToxic == nil || st.HTTPToxic.Name == "" {
+			return errors.New("remove_http_toxic needs an http_toxic with a name")
+		}
+	default:
+		return fmt.Errorf("action must be one of %s", strings.Join([]string{
+			ActionAddToxic, ActionRemoveToxic, ActionAddHTTPToxic, ActionRemoveHTTPToxic,
+			ActionEnable, ActionDisable, ActionReset,
+		}, ", "))
+	}
+	if st.Route == "" {
+		return fmt.Errorf("%s needs a route", st.Action)
+	}
+	return nil
+}
+
+// scenarioRoute returns the name of the route referred to by name or prefix.
+func (s *ShrikeServer) scenarioRoute(ref string) (string, error) {
+	if name := s.routeByRef(ref); s.ProxyStore.Get(name) != nil {
+		return name, nil
+	}
+	return "", fmt.Errorf("no route %q", ref)
+}
+
+// runStep makes the step's change.
+func (s *ShrikeServer) runStep(st ScenarioStep) error {
+	if st.Action == ActionReset {
+		return s.resetToxics()
+	}
+	route, err := s.scenarioRoute(st.Route)
+	if err != nil {
+		return err
+	}
+
+	switch st.Action {
+	case ActionAddHTTPToxic:
+		t := *st.HTTPToxic
+		if t.Name == "" {
+			t.Name = t.Type
+		}
+		toxics := HTTPToxics{}
+		for _, current := range s.httpToxics(route) {
+			if current.Name != t.Name {
+				toxics = append(toxics, current)
+			}
+		}
+		s.setHTTPToxics(route, append(toxics, t))
+		return nil
+	case ActionRemoveHTTPToxic:
+		toxics := HTTPToxics{}
+		for _, current := range s.httpToxics(route) {
+			if current.Name != st.HTTPToxic.Name {
+				toxics = append(toxics, current)
+			}
+		}
+		s.setHTTPToxics(route, toxics)
+		return nil
+	}
+
+	proxy, err := s.client.Proxy(route)
+	if err != nil {
+		return err
+	}
+	switch st.Action {
+	case ActionEnable:
+		return proxy.Enable()
+	case ActionDisable:
+		return proxy.Disable()
+	case ActionAddToxic:
+		expiresAt, err := st.Toxic.expiresAt(time.Now())
+		if err != nil {
+			return err
+		}
+		_, err = s.addToxic(proxy, st.Toxic.Toxic, expiresAt)
+		return err
+	case ActionRemoveToxic:
+		return s.removeToxic(proxy, st.Toxic.Name)
+	}
+	return nil
+}
+
+// runScenario runs the steps in order of their offsets, stopping at the first
+// step that fails or when cancelled.
+func (s *ShrikeServer) runScenario(run *scenarioRun) {
+	order := make([]int, len(run.offset))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return run.offset[order[a]] < run.offset[order[b]]
+	})
+
+	state := StateCompleted
+	for _, i := range order {
+		timer := time.NewTimer(time.Until(run.status.StartedAt.Add(run.offset[i])))
+		select {
+		case <-run.cancel:
+			timer.Stop()
+			state = StateCancelled
+		case <-timer.C:
+		}
+		if state == StateCancelled {
+			break
+		}
+
+		s.mu.Lock()
+		step := run.status.Steps[i].ScenarioStep
+		s.mu.Unlock()
+		s.changeMu.Lock()
+		err := s.runStep(step)
+		s.changed()
+		s.changeMu.Unlock()
+
+		now := time.Now()
+		s.mu.Lock()
+		run.status.Steps[i].RanAt = &now
+		run.status.Steps[i].State = StateCompleted
+		if err != nil {
+			run.status.Steps[i].State = StateFailed
+			run.status.Steps[i].Error = err.Error()
+		}
+		s.mu.Unlock()
+		if err != nil {
+			log.WithFields(log.Fields{
+				"scenario": run.status.ID,
+				"step":     i,
+				"err":      err,
+			}).Error("Scenario step failed")
+			state = StateFailed
+			break
+		}
+	}
+
+	now := time.Now()
+	s.mu.Lock()
+	run.status.State = state
+	run.status.FinishedAt = &now
+	s.pruneScenarios()
+	s.mu.Unlock()
+	log.WithFields(log.Fields{
+		"scenario": run.status.ID,
+		"state":    state,
+	}).Info("Scenario finished")
+}
+
+// pruneScenarios forgets the oldest finished scenarios beyond the most recent
+// maxFinishedScenarios. s.mu must be held.
+func (s *ShrikeServer) pruneScenarios() {
+	finished := []*scenarioRun{}
+	for _, run := range s.scenarios {
+		if run.status.FinishedAt != nil {
+			finished = append(finished, run)
+		}
+	}
+	if len(finished) <= maxFinishedScenarios {
+		return
+	}
+	sort.Slice(finished, func(i, j int) bool {
+		return finished[i].status.FinishedAt.After(*finished[j].status.FinishedAt)
+	})
+	for _, run := range finished[maxFinishedScenarios:] {
+		delete(s.scenarios, run.status.ID)
+	}
+}
+
+// scenarioStatus returns a copy of the scenario's status.
+func (s *ShrikeServer) scenarioStatus(id string) (ScenarioStatus, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	run, ok := s.scenarios[id]
+	if !ok {
+		return ScenarioStatus{}, false
+	}
+	status := run.status
+	status.Steps = append([]StepStatus{}, run.status.Steps...)
+	return status, true
+}
+
+// GetScenarios lists every scenario run, most recent first.
+func (s *ShrikeServer) GetScenarios(w http.ResponseWriter, req *http.Request) {
+	s.mu.RLock()
+	ids := []string{}
+	for id := range s.scenarios {
+		ids = append(ids, id)
+	}
+	s.mu.RUnlock()
+
+	scenarios := []ScenarioStatus{}
+	for _, id := range ids {
+		if status, ok := s.scenarioStatus(id); ok {
+			scenarios = append(scenarios, status)
+		}
+	}
+	sort.Slice(scenarios, func(i, j int) bool {
+		return scenarios[i].StartedAt.After(scenarios[j].StartedAt)
+	})
+
+	b, _ := json.Marshal(scenarios)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
+
+// CreateScenario starts running the scenario in the request body.
+func (s *ShrikeServer) CreateScenario(w http.ResponseWriter, req *http.Request) {
+	defer req.Body.Close()
+	body, _ := ioutil.ReadAll(req.Body)
+	doc := &Scenario{}
+	if err := json.Unmarshal(body, &doc); err != nil {
+		log.Errorf("Error unmarshaling body %s", err)
+		RespondWithError(w, http.StatusBadRequest, JSONError{
+			Status:  "Bad Request",
+			Message: "Request body is not a valid JSON Scenario object.",
+		})
+		return
+	}
+	err := doc.Validate()
+	for i := 0; err == nil && i < len(doc.Steps); i++ {
+		if doc.Steps[i].Action != ActionReset {
+			if _, rerr := s.scenarioRoute(doc.Steps[i].Route); rerr != nil {
+				err = fmt.Errorf("step %d: %s", i, rerr)
+			}
+		}
+	}
+	if err != nil {
+		log.WithField("err", err).Info("Invalid scenario")
+		RespondWithError(w, http.StatusBadRequest, JSONError{
+			Status:  "Bad Request",
+			Message: "Scenario is not valid: " + err.Error(),
+		})
+		return
+	}
+
+	run := &scenarioRun{
+		status: ScenarioStatus{
+			ID:        scenarioID(),
+			Name:      doc.Name,
+			State:     StateRunning,
+			StartedAt: time.Now(),
+		},
+		cancel: make(chan struct{}),
+	}
+	for _, step := range doc.Steps {
+		at, _ := time.ParseDuration(step.At)
+		run.offset = append(run.offset, at)
+		run.status.Steps = append(run.status.Steps, StepStatus{ScenarioStep: step, State: StatePending})
+	}
+	s.mu.Lock()
+	s.scenarios[run.status.ID] = run
+	s.mu.Unlock()
+	status, _ := s.scenarioStatus(run.status.ID)
+	go s.runScenario(run)
+	log.WithFields(log.Fields{
+		"scenario": run.status.ID,
+		"name":     doc.Name,
+		"steps":    len(doc.Steps),
+	}).Info("Scenario started")
+
+	b, _ := json.Marshal(status)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	w.Write(b)
+}
+
+// GetScenario reports the progress of a scenario.
+func (s *ShrikeServer) GetScenario(w http.ResponseWriter, req *http.Request) {
+	status, ok := s.scenarioStatus(chi.URLParam(req, "scenario"))
+	if !ok {
+		RespondWithError(w, http.StatusNotFound, JSONError{
+			Status:  "No Scenario",
+			Message: "No scenario by that id.",
+		})
+		return
+	}
+
+	b, _ := json.Marshal(status)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
+
+// cancelScenarios stops every running scenario before its next step.
+func (s *ShrikeServer) cancelScenarios() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, run := range s.scenarios {
+		if run.status.State == StateRunning && !run.cancelled {
+			close(run.cancel)
+			run.cancelled = true
+		}
+	}
+}
+
+// CancelScenario stops a running scenario before its next step. Changes already
+// made are left in place.
+func (s *ShrikeServer) CancelScenario(w http.ResponseWriter, req *http.Request) {
+	id := chi.URLParam(req, "scenario")
+	s.mu.Lock()
+	run, ok := s.scenarios[id]
+	if ok && run.status.State == StateRunning && !run.cancelled {
+		close(run.cancel)
+		run.cancelled = true
+	}
+	s.mu.Unlock()
+	if !ok {
+		RespondWithError(w, http.StatusNotFound, JSONError{
+			Status:  "No Scenario",
+			Message: "No scenario by that id.",
+		})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// scenarioID returns a random id for a scenario.
+func scenarioID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,117 @@
+package api
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"time"
+
+	toxy "github.com/Shopify/toxiproxy/client"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/richardbolt/shrike/client"
+)
+
+var _ = Describe("Scenarios", func() {
+	var (
+		upstream *httptest.Server
+		s        *ShrikeServer
+		api      *client.Client
+		route    *client.Route
+	)
+
+	BeforeEach(func() {
+		upstream = httptest.NewServer(nil)
+		cfg := testConfig(upstream.URL)
+		s = startServer(cfg)
+		api = apiClient(cfg)
+		var err error
+		route, err = api.CreateRoute(client.Route{Prefix: "/orders"})
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		s.Close()
+		upstream.Close()
+	})
+
+	slow := &client.StepToxic{Toxic: toxy.Toxic{Name: "slow", Type: "latency", Attributes: toxy.Attributes{"latency": 10}}}
+	state := func(id string) func() string {
+		return func() string {
+			status, err := api.Scenario(id)
+			Expect(err).NotTo(HaveOccurred())
+			return status.State
+		}
+	}
+
+	It("runs each step at its offset from the start", func() {
+		status, err := api.StartScenario(client.Scenario{Steps: []client.ScenarioStep{
+			{At: "300ms", Action: ActionRemoveToxic, Route: "/orders", Toxic: slow},
+			{At: "0s", Action: ActionAddToxic, Route: "/orders", Toxic: slow},
+		}})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(status.State).To(Equal(StateRunning))
+
+		Eventually(route.Toxics, time.Second, 20*time.Millisecond).Should(HaveLen(1))
+		Eventually(state(status.ID), 2*time.Second, 20*time.Millisecond).Should(Equal(StateCompleted))
+		Expect(route.Toxics()).To(BeEmpty())
+
+		status, err = api.Scenario(status.ID)
+		Expect(err).NotTo(HaveOccurred())
+		for i, at := range []time.Duration{300 * time.Millisecond, 0} {
+			Expect(status.Steps[i].State).To(Equal(StateCompleted))
+			Expect(status.Steps[i].RanAt.Sub(status.StartedAt)).To(BeNumerically(">=", at))
+		}
+		Expect(*status.Steps[0].RanAt).To(BeTemporally(">", *status.Steps[1].RanAt))
+	})
+
+	It("stops at the first step that fails", func() {
+		status, err := api.StartScenario(client.Scenario{Steps: []client.ScenarioStep{
+			{At: "0s", Action: ActionRemoveToxic, Route: "/orders", Toxic: slow},
+			{At: "0s", Action: ActionAddToxic, Route: "/orders", Toxic: slow},
+		}})
+		Expect(err).NotTo(HaveOccurred())
+
+		Eventually(state(status.ID), time.Second, 20*time.Millisecond).Should(Equal(StateFailed))
+		status, err = api.Scenario(status.ID)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(status.Steps[0].State).To(Equal(StateFailed))
+		Expect(status.Steps[0].Error).NotTo(BeEmpty())
+		Expect(status.Steps[1].State).To(Equal(StatePending))
+		Expect(route.Toxics()).To(BeEmpty())
+	})
+
+	It("makes no more changes once cancelled", func() {
+		status, err := api.StartScenario(client.Scenario{Steps: []client.ScenarioStep{
+			{At: "300ms", Action: ActionAddToxic, Route: "/orders", Toxic: slow},
+		}})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(api.CancelScenario(status.ID)).To(Succeed())
+
+		Eventually(state(status.ID), time.Second, 20*time.Millisecond).Should(Equal(StateCancelled))
+		Consistently(route.Toxics, 500*time.Millisecond, 50*time.Millisecond).Should(BeEmpty())
+	})
+
+	It("keeps the running scenarios and only the most recently finished", func() {
+		finished := time.Now()
+		s.mu.Lock()
+		for i := 0; i < maxFinishedScenarios+10; i++ {
+			at := finished.Add(time.Duration(i) * time.Second)
+			id := fmt.Sprintf("finished-%d", i)
+			s.scenarios[id] = &scenarioRun{status: ScenarioStatus{ID: id, State: StateCompleted, FinishedAt: &at}}
+		}
+		s.scenarios["running"] = &scenarioRun{status: ScenarioStatus{ID: "running", State: StateRunning}, cancel: make(chan struct{})}
+		s.pruneScenarios()
+		s.mu.Unlock()
+
+		scenarios, err := api.Scenarios()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(scenarios).To(HaveLen(maxFinishedScenarios + 1))
+		_, err = api.Scenario("running")
+		Expect(err).NotTo(HaveOccurred())
+		_, err = api.Scenario("finished-9")
+		Expect(client.IsNotFound(err)).To(BeTrue())
+		_, err = api.Scenario("finished-10")
+		Expect(err).NotTo(HaveOccurred())
+	})
+})
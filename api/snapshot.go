@@ -0,0 +1,108 @@
+package api
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/richardbolt/shrike/store"
+	log "github.com/sirupsen/logrus"
+)
+
+// Snapshot is the full chaos state: every route with its proxy settings and toxics.
+type Snapshot struct {
+	Routes []RouteConfig `json:"routes"`
+}
+
+// GetSnapshot returns the current state of every route.
+func (s *ShrikeServer) GetSnapshot(w http.ResponseWriter, req *http.Request) {
+	routes, err := s.routeConfigs()
+	if err != nil {
+		log.WithField("err", err).Error("Error getting proxies for a snapshot")
+		RespondWithError(w, http.StatusInternalServerError, JSONError{
+			Status:  "Server Error",
+			Message: "Could not create the snapshot.",
+		})
+		return
+	}
+
+	b, _ := json.Marshal(Snapshot{Routes: routes})
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
+
+// PutSnapshot replaces the current state with the snapshot in the request body.
+// If the snapshot can't be applied in full the previous state is put back.
+func (s *ShrikeServer) PutSnapshot(w http.ResponseWriter, req *http.Request) {
+	defer req.Body.Close()
+	body, _ := ioutil.ReadAll(req.Body)
+	doc := &Snapshot{}
+	if err := json.Unmarshal(body, &doc); err != nil {
+		log.Errorf("Error unmarshaling body %s", err)
+		RespondWithError(w, http.StatusBadRequest, JSONError{
+			Status:  "Bad Request",
+			Message: "Request body is not a valid JSON Snapshot object.",
+		})
+		return
+	}
+	if err := ValidateRoutes(doc.Routes); err != nil {
+		log.WithField("err", err).Info("Invalid snapshot")
+		RespondWithError(w, http.StatusBadRequest, JSONError{
+			Status:  "Bad Request",
+			Message: "Snapshot is not valid: " + err.Error(),
+		})
+		return
+	}
+
+	s.changeMu.Lock()
+	defer s.changeMu.Unlock()
+	previous, err := s.routeConfigs()
+	if err == nil {
+		if err = s.replaceRoutes(doc.Routes); err != nil {
+			if rerr := s.replaceRoutes(previous); rerr != nil {
+				log.WithField("err", rerr).Error("Error putting back the state from before the snapshot")
+			}
+		}
+	}
+	s.changed()
+	if err == store.ErrPortsExhausted {
+		RespondWithError(w, http.StatusConflict, JSONError{
+			Status:  "Conflict",
+			Message: "No free Toxiproxy ports are left in the port range.",
+		})
+		return
+	}
+	if err != nil {
+		log.WithField("err", err).Error("Error restoring a snapshot")
+		RespondWithError(w, http.StatusInternalServerError, JSONError{
+			Status:  "Server Error",
+			Message: "Could not restore the snapshot.",
+		})
+		return
+	}
+
+	s.GetSnapshot(w, req)
+}
+
+// replaceRoutes removes every route not in routes and applies the rest.
+func (s *ShrikeServer) replaceRoutes(routes []RouteConfig) error {
+	wanted := map[string]bool{}
+	for _, rc := range routes {
+		wanted[s.routeName(rc.Route)] = true
+	}
+	for k, v := range s.ProxyStore.ToMap() {
+		if wanted[k] {
+			continue
+		}
+		if err := v.Proxy.Delete(); err != nil {
+			return err
+		}
+		s.removeRoute(v.Proxy)
+	}
+	for _, rc := range routes {
+		if _, err := s.applyRouteConfig(rc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package api
+
+import (
+	"net/http/httptest"
+	"time"
+
+	toxy "github.com/Shopify/toxiproxy/client"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/richardbolt/shrike/client"
+)
+
+var _ = Describe("Snapshots", func() {
+	var (
+		upstream *httptest.Server
+		s        *ShrikeServer
+		c        *client.Client
+	)
+
+	BeforeEach(func() {
+		upstream = httptest.NewServer(nil)
+		cfg := testConfig(upstream.URL)
+		s = startServer(cfg)
+		c = apiClient(cfg)
+	})
+
+	AfterEach(func() {
+		s.Close()
+		upstream.Close()
+	})
+
+	It("puts back the routes and toxics", func() {
+		r, err := c.CreateRoute(client.Route{Prefix: "/orders"})
+		Expect(err).NotTo(HaveOccurred())
+		_, err = r.AddToxic("slow", "latency", "", 1, toxy.Attributes{"latency": 100})
+		Expect(err).NotTo(HaveOccurred())
+		snapshot, err := c.Snapshot()
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(r.RemoveToxic("slow")).To(Succeed())
+		_, err = c.CreateRoute(client.Route{Prefix: "/payments"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(c.RestoreSnapshot(snapshot)).To(Succeed())
+
+		routes, err := c.Routes()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(routes).To(HaveLen(1))
+		for _, route := range routes {
+			Expect(route.Prefix).To(Equal("/orders"))
+			r = route
+		}
+		toxics, err := r.Toxics()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(toxics).To(HaveLen(1))
+		Expect(toxics[0].Name).To(Equal("slow"))
+	})
+
+	It("keeps route changes from interleaving with a restore", func() {
+		// Hold the lock a restore holds throughout.
+		s.changeMu.Lock()
+		done := make(chan error)
+		go func() {
+			_, err := c.CreateRoute(client.Route{Prefix: "/orders"})
+			done <- err
+		}()
+		Consistently(done, 200*time.Millisecond).ShouldNot(Receive())
+		s.changeMu.Unlock()
+		Eventually(done, time.Second).Should(Receive(BeNil()))
+	})
+})
@@ -0,0 +1,34 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/Shopify/toxiproxy"
+	"github.com/gorilla/mux"
+)
+
+// ToxiproxyHandler serves the embedded Toxiproxy server's api. It has the same
+// routes as toxiproxy.ApiServer.Listen, which registers them on
+// http.DefaultServeMux and so can only be used once in a process. There's
+// nothing to serve with an external Toxiproxy.
+func (s *ShrikeServer) ToxiproxyHandler() http.Handler {
+	t := s.toxiproxy
+	if t == nil {
+		return http.NotFoundHandler()
+	}
+	r := mux.NewRouter()
+	r.HandleFunc("/reset", t.ResetState).Methods("POST")
+	r.HandleFunc("/proxies", t.ProxyIndex).Methods("GET")
+	r.HandleFunc("/proxies", t.ProxyCreate).Methods("POST")
+	r.HandleFunc("/populate", t.Populate).Methods("POST")
+	r.HandleFunc("/proxies/{proxy}", t.ProxyShow).Methods("GET")
+	r.HandleFunc("/proxies/{proxy}", t.ProxyUpdate).Methods("POST")
+	r.HandleFunc("/proxies/{proxy}", t.ProxyDelete).Methods("DELETE")
+	r.HandleFunc("/proxies/{proxy}/toxics", t.ToxicIndex).Methods("GET")
+	r.HandleFunc("/proxies/{proxy}/toxics", t.ToxicCreate).Methods("POST")
+	r.HandleFunc("/proxies/{proxy}/toxics/{toxic}", t.ToxicShow).Methods("GET")
+	r.HandleFunc("/proxies/{proxy}/toxics/{toxic}", t.ToxicUpdate).Methods("POST")
+	r.HandleFunc("/proxies/{proxy}/toxics/{toxic}", t.ToxicDelete).Methods("DELETE")
+	r.HandleFunc("/version", t.Version).Methods("GET")
+	return toxiproxy.StopBrowsersMiddleware(r)
+}
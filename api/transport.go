@@ -0,0 +1,141 @@
+package api
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/vulcand/oxy/utils"
+)
+
+// RouteTLS holds the TLS settings used to re-originate TLS to an https upstream
+// for a route once its traffic has passed through Toxiproxy.
+type RouteTLS struct {
+	ServerName         string `json:"server_name,omitempty" yaml:"server_name"`
+	CAFile             string `json:"ca_file,omitempty" yaml:"ca_file"`
+	InsecureSkipVerify bool   `json:"insecure_skip_verify,omitempty" yaml:"insecure_skip_verify"`
+}
+
+// Config returns a tls.Config for the upstream. SNI defaults to the upstream host
+// as the connection is made to the Toxiproxy listener rather than the upstream.
+func (r *RouteTLS) Config(upstream *url.URL) (*tls.Config, error) {
+	c := &tls.Config{ServerName: upstream.Hostname()}
+	if r == nil {
+		return c, nil
+	}
+	if r.ServerName != "" {
+		c.ServerName = r.ServerName
+	}
+	c.InsecureSkipVerify = r.InsecureSkipVerify
+	if r.CAFile != "" {
+		pem, err := ioutil.ReadFile(r.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", r.CAFile)
+		}
+		c.RootCAs = pool
+	}
+	return c, nil
+}
+
+// routeTransport is the forwarder's http.RoundTripper. Requests to a Toxiproxy
+// listener with registered TLS settings use that route's transport, all other
+// requests go through the base transport.
+type routeTransport struct {
+	mu     sync.RWMutex
+	base   *http.Transport
+	routes map[string]*http.Transport
+}
+
+func newRouteTransport() *routeTransport {
+	return &routeTransport{
+		base:   newTransport(nil),
+		routes: map[string]*http.Transport{},
+	}
+}
+
+// RoundTrip the request via the transport registered for its host.
+func (t *routeTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.mu.RLock()
+	rt, ok := t.routes[req.URL.Host]
+	t.mu.RUnlock()
+	if !ok {
+		return t.base.RoundTrip(req)
+	}
+	return rt.RoundTrip(req)
+}
+
+// Set the TLS config used for requests to the listen address.
+func (t *routeTransport) Set(listen string, c *tls.Config) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if old, ok := t.routes[listen]; ok {
+		old.CloseIdleConnections()
+	}
+	t.routes[listen] = newTransport(c)
+}
+
+// Remove the transport for the listen address.
+func (t *routeTransport) Remove(listen string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if old, ok := t.routes[listen]; ok {
+		old.CloseIdleConnections()
+		delete(t.routes, listen)
+	}
+}
+
+// newTransport mirrors http.DefaultTransport with the given TLS config.
+func newTransport(c *tls.Config) *http.Transport {
+	return &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+		TLSClientConfig:       c,
+	}
+}
+
+// upstreamError responds to a request the forwarder couldn't get a response
+// for. Errors oxy doesn't classify, such as an upstream certificate that isn't
+// trusted, are a bad gateway rather than an internal error.
+var upstreamError = utils.ErrorHandlerFunc(func(w http.ResponseWriter, req *http.Request, err error) {
+	if _, ok := err.(net.Error); ok || err == io.EOF || err == context.Canceled {
+		utils.DefaultHandler.ServeHTTP(w, req, err)
+		return
+	}
+	log.WithFields(log.Fields{
+		"path": req.URL.Path,
+		"err":  err,
+	}).Warn("Error proxying request upstream")
+	w.WriteHeader(http.StatusBadGateway)
+	w.Write([]byte(http.StatusText(http.StatusBadGateway)))
+})
+
+// upstreamAddr returns the host:port Toxiproxy should dial for the upstream URL.
+func upstreamAddr(u *url.URL) string {
+	if u.Port() != "" {
+		return u.Host
+	}
+	if u.Scheme == "https" {
+		return net.JoinHostPort(u.Hostname(), "443")
+	}
+	return net.JoinHostPort(u.Hostname(), "80")
+}
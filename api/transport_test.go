@@ -0,0 +1,99 @@
+package api
+
+import (
+	"crypto/tls"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/richardbolt/shrike/client"
+)
+
+var _ = Describe("Re-originating TLS", func() {
+	var (
+		upstream *httptest.Server
+		dir      string
+		caFile   string
+		cfg      Config
+		s        *ShrikeServer
+		mu       sync.Mutex
+		sni      []string
+	)
+
+	BeforeEach(func() {
+		sni = nil
+		upstream = httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			w.Write([]byte("secure"))
+		}))
+		upstream.TLS = &tls.Config{GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			mu.Lock()
+			sni = append(sni, hello.ServerName)
+			mu.Unlock()
+			return nil, nil
+		}}
+		upstream.StartTLS()
+
+		var err error
+		dir, err = ioutil.TempDir("", "shrike-tls")
+		Expect(err).NotTo(HaveOccurred())
+		caFile = filepath.Join(dir, "ca.pem")
+		ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: upstream.Certificate().Raw})
+		Expect(ioutil.WriteFile(caFile, ca, 0600)).To(Succeed())
+
+		cfg = testConfig(upstream.URL)
+		s = startServer(cfg)
+	})
+
+	AfterEach(func() {
+		s.Close()
+		upstream.Close()
+		os.RemoveAll(dir)
+	})
+
+	get := func(path string) (int, string) {
+		resp, err := http.Get(fmt.Sprintf("http://127.0.0.1:%d%s", cfg.Port, path))
+		Expect(err).NotTo(HaveOccurred())
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+
+	It("trusts the upstream's certificate from the CA file", func() {
+		_, err := apiClient(cfg).CreateRoute(client.Route{Prefix: "/orders", TLS: &client.RouteTLS{CAFile: caFile}})
+		Expect(err).NotTo(HaveOccurred())
+
+		status, body := get("/orders")
+		Expect(status).To(Equal(http.StatusOK))
+		Expect(body).To(Equal("secure"))
+	})
+
+	It("sends the server name as SNI", func() {
+		_, err := apiClient(cfg).CreateRoute(client.Route{
+			Prefix: "/orders",
+			TLS:    &client.RouteTLS{CAFile: caFile, ServerName: "example.com"},
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		status, _ := get("/orders")
+		Expect(status).To(Equal(http.StatusOK))
+		mu.Lock()
+		defer mu.Unlock()
+		Expect(sni).To(ContainElement("example.com"))
+	})
+
+	It("fails with a bad gateway when the certificate isn't trusted", func() {
+		_, err := apiClient(cfg).CreateRoute(client.Route{Prefix: "/orders"})
+		Expect(err).NotTo(HaveOccurred())
+
+		status, _ := get("/orders")
+		Expect(status).To(Equal(http.StatusBadGateway))
+	})
+})
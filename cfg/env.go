@@ -1,13 +1,41 @@
 package cfg
 
-import "github.com/kelseyhightower/envconfig"
+import (
+	"time"
+
+	"github.com/kelseyhightower/envconfig"
+)
 
 // Env represents the possible environment variable config params.
 type Env struct {
-	Host        string `default:"0.0.0.0"`
-	Port        int    `default:"8080"`
-	APIPort     int    `envconfig:"API_PORT" default:"8475"`
-	UpstreamURL string `envconfig:"UPSTREAM_URL" default:"http://localhost"`
+	Host               string        `default:"0.0.0.0"`
+	Port               int           `default:"8080"`
+	APIPort            int           `envconfig:"API_PORT" default:"8475"`
+	UpstreamURL        string        `envconfig:"UPSTREAM_URL" default:"http://localhost"`
+	UpstreamHosts      string        `envconfig:"UPSTREAM_HOSTS"`
+	ToxyExternal       bool          `envconfig:"TOXY_EXTERNAL" default:"false"`
+	ToxyAddress        string        `envconfig:"TOXY_ADDRESS" default:"127.0.0.1"`
+	ToxyAPIPort        int           `envconfig:"TOXY_API_PORT" default:"8474"`
+	ToxyListenHost     string        `envconfig:"TOXY_LISTEN_HOST"`
+	ToxyPathSeparator  string        `envconfig:"TOXY_PATH_SEPARATOR" default:"__"`
+	ToxyEphemeralPorts bool          `envconfig:"TOXY_EPHEMERAL_PORTS" default:"false"`
+	ToxyPortMin        int           `envconfig:"TOXY_PORT_MIN" default:"10000"`
+	ToxyPortMax        int           `envconfig:"TOXY_PORT_MAX" default:"65535"`
+	TLSCert            string        `envconfig:"TLS_CERT"`
+	TLSKey             string        `envconfig:"TLS_KEY"`
+	TLSSelfSigned      bool          `envconfig:"TLS_SELF_SIGNED" default:"false"`
+	TLSCAOut           string        `envconfig:"TLS_CA_OUT"`
+	StateFile          string        `envconfig:"STATE_FILE"`
+	ConfigFile         string        `envconfig:"CONFIG_FILE"`
+	WatchConfig        bool          `envconfig:"WATCH_CONFIG" default:"false"`
+	AccessLog          string        `envconfig:"ACCESS_LOG"`
+	AccessLogFormat    string        `envconfig:"ACCESS_LOG_FORMAT" default:"json"`
+	APITokens          string        `envconfig:"API_TOKENS"`
+	APIReadTokens      string        `envconfig:"API_READ_TOKENS"`
+	APIClientCA        string        `envconfig:"API_CLIENT_CA"`
+	DrainTimeout       time.Duration `envconfig:"DRAIN_TIMEOUT" default:"15s"`
+	DriftInterval      time.Duration `envconfig:"DRIFT_INTERVAL" default:"1m"`
+	DriftAdoptNamed    bool          `envconfig:"DRIFT_ADOPT_NAMED" default:"false"`
 }
 
 // New returns a new configuration, populated from environment variables and/or defaults.
@@ -0,0 +1,34 @@
+package cfg_test
+
+import (
+	"os"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/richardbolt/shrike/cfg"
+)
+
+var _ = Describe("Env", func() {
+	It("defaults to the documented ports and Toxiproxy settings", func() {
+		env := cfg.New()
+		Expect(env.Port).To(Equal(8080))
+		Expect(env.APIPort).To(Equal(8475))
+		Expect(env.ToxyAddress).To(Equal("127.0.0.1"))
+		Expect(env.ToxyAPIPort).To(Equal(8474))
+		Expect(env.ToxyPathSeparator).To(Equal("__"))
+		Expect(env.ToxyPortMin).To(Equal(10000))
+		Expect(env.ToxyPortMax).To(Equal(65535))
+	})
+
+	It("reads the Toxiproxy settings from the environment", func() {
+		os.Setenv("TOXY_API_PORT", "9474")
+		os.Setenv("TOXY_PATH_SEPARATOR", "-")
+		defer os.Unsetenv("TOXY_API_PORT")
+		defer os.Unsetenv("TOXY_PATH_SEPARATOR")
+
+		env := cfg.New()
+		Expect(env.ToxyAPIPort).To(Equal(9474))
+		Expect(env.ToxyPathSeparator).To(Equal("-"))
+	})
+})
@@ -0,0 +1,24 @@
+package cfg
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ParseUpstreamHosts parses a comma separated list of host=url pairs, such as
+// "api.example.com=http://api:8080,web.example.com=https://web".
+func ParseUpstreamHosts(s string) (map[string]string, error) {
+	hosts := map[string]string{}
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 || kv[0] == "" || kv[1] == "" {
+			return nil, fmt.Errorf("%q is not a host=url pair", pair)
+		}
+		hosts[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+	}
+	return hosts, nil
+}
@@ -0,0 +1,36 @@
+package cfg_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/richardbolt/shrike/cfg"
+)
+
+var _ = Describe("ParseUpstreamHosts", func() {
+	It("parses host=url pairs", func() {
+		hosts, err := cfg.ParseUpstreamHosts(" api.example.com = http://api:8080 ,web.example.com=https://web,")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(hosts).To(Equal(map[string]string{
+			"api.example.com": "http://api:8080",
+			"web.example.com": "https://web",
+		}))
+	})
+
+	It("keeps = in the url", func() {
+		hosts, err := cfg.ParseUpstreamHosts("api.example.com=http://api/?a=b")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(hosts).To(HaveKeyWithValue("api.example.com", "http://api/?a=b"))
+	})
+
+	It("has no hosts for an empty list", func() {
+		Expect(cfg.ParseUpstreamHosts("")).To(BeEmpty())
+	})
+
+	It("rejects entries that aren't pairs", func() {
+		for _, s := range []string{"api.example.com", "=http://api", "api.example.com="} {
+			_, err := cfg.ParseUpstreamHosts(s)
+			Expect(err).To(HaveOccurred(), s)
+		}
+	})
+})
@@ -0,0 +1,31 @@
+package cfg
+
+import (
+	"fmt"
+	"io/ioutil"
+
+	"github.com/richardbolt/shrike/api"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Routes is the declarative route configuration file.
+type Routes struct {
+	Routes []api.RouteConfig `yaml:"routes"`
+}
+
+// LoadRoutes reads and validates the route configuration file at path.
+// Both YAML and JSON files are accepted.
+func LoadRoutes(path string) ([]api.RouteConfig, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	f := Routes{}
+	if err := yaml.Unmarshal(b, &f); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	if err := api.ValidateRoutes(f.Routes); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	return f.Routes, nil
+}
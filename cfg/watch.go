@@ -0,0 +1,47 @@
+package cfg
+
+import (
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	log "github.com/sirupsen/logrus"
+)
+
+// WatchFile calls onChange after the file at path is written or replaced.
+// The directory is watched so editors that save by renaming over the file are seen.
+func WatchFile(path string, onChange func()) error {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	if err := w.Add(filepath.Dir(path)); err != nil {
+		w.Close()
+		return err
+	}
+
+	go func() {
+		// Editors often write a file in several steps so changes are settled first.
+		var settled <-chan time.Time
+		for {
+			select {
+			case e, ok := <-w.Events:
+				if !ok {
+					return
+				}
+				if filepath.Clean(e.Name) == filepath.Clean(path) && e.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename) != 0 {
+					settled = time.After(100 * time.Millisecond)
+				}
+			case err, ok := <-w.Errors:
+				if !ok {
+					return
+				}
+				log.WithField("err", err).Error("Error watching route config file")
+			case <-settled:
+				settled = nil
+				onChange()
+			}
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,226 @@
+// Package client provides a Go client for the Shrike api, in the style of the
+// Toxiproxy client.
+//
+//	c := client.NewClient("localhost:8475")
+//	route, err := c.CreateRoute(client.Route{Prefix: "/orders"})
+//	_, err = route.AddToxic("slow", "latency", "", 1, toxy.Attributes{"latency": 500})
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	toxy "github.com/Shopify/toxiproxy/client"
+)
+
+// Client for the Shrike api.
+type Client struct {
+	// Token is sent as a bearer token when the api requires one.
+	Token string
+	// HTTPClient makes the requests, http.DefaultClient when nil.
+	HTTPClient *http.Client
+	endpoint   string
+}
+
+// NewClient returns a client for the Shrike api at endpoint, such as
+// "localhost:8475" or "https://shrike.internal:8475".
+func NewClient(endpoint string) *Client {
+	if !strings.HasPrefix(endpoint, "http://") && !strings.HasPrefix(endpoint, "https://") {
+		endpoint = "http://" + endpoint
+	}
+	return &Client{endpoint: strings.TrimSuffix(endpoint, "/")}
+}
+
+// APIError is an error response from the Shrike api.
+type APIError struct {
+	StatusCode int    `json:"-"`
+	Status     string `json:"string"`
+	Message    string `json:"message"`
+}
+
+func (err *APIError) Error() string {
+	return fmt.Sprintf("shrike: %d %s: %s", err.StatusCode, err.Status, err.Message)
+}
+
+// IsNotFound reports whether err is an api error for something that doesn't exist.
+func IsNotFound(err error) bool {
+	e, ok := err.(*APIError)
+	return ok && (e.StatusCode == http.StatusNotFound || e.StatusCode == http.StatusGone)
+}
+
+// Routes returns every route by name.
+func (c *Client) Routes() (map[string]*Route, error) {
+	routes := map[string]*routeWithProxy{}
+	if err := c.do("GET", "/routes", nil, &routes); err != nil {
+		return nil, err
+	}
+	// The api keys routes by prefix unless they were given a name.
+	result := map[string]*Route{}
+	for _, r := range routes {
+		result[r.Route.Name] = r.route(c)
+	}
+	return result, nil
+}
+
+// Route returns the route by name or path prefix, such as "/orders/v1".
+func (c *Client) Route(name string) (*Route, error) {
+	r := &routeWithProxy{}
+	if err := c.do("GET", "/routes/"+url.PathEscape(name), nil, r); err != nil {
+		return nil, err
+	}
+	return r.route(c), nil
+}
+
+// CreateRoute creates the route, or returns the existing route by the same name.
+func (c *Client) CreateRoute(r Route) (*Route, error) {
+	proxy := &toxy.Proxy{}
+	if err := c.do("POST", "/routes", r, proxy); err != nil {
+		return nil, err
+	}
+	r.Name = proxy.Name
+	r.Proxy = proxy
+	r.client = c
+	return &r, nil
+}
+
+// ResetState removes every toxic and HTTP toxic and enables every route.
+func (c *Client) ResetState() error {
+	return c.do("POST", "/routes/reset", nil, nil)
+}
+
+// RemoveAllRoutes deletes every route.
+func (c *Client) RemoveAllRoutes() error {
+	return c.do("DELETE", "/routes", nil, nil)
+}
+
+// Snapshot returns the state of every route.
+func (c *Client) Snapshot() (*Snapshot, error) {
+	snapshot := &Snapshot{}
+	if err := c.do("GET", "/snapshot", nil, snapshot); err != nil {
+		return nil, err
+	}
+	return snapshot, nil
+}
+
+// RestoreSnapshot replaces the state of every route with the snapshot.
+func (c *Client) RestoreSnapshot(snapshot *Snapshot) error {
+	return c.do("PUT", "/snapshot", snapshot, nil)
+}
+
+// ConfigStatus returns the result of the last route config file reload.
+func (c *Client) ConfigStatus() (*ConfigStatus, error) {
+	status := &ConfigStatus{}
+	if err := c.do("GET", "/config/status", nil, status); err != nil {
+		return nil, err
+	}
+	return status, nil
+}
+
+// Drift returns the result of the last check of the routes against the
+// proxies in Toxiproxy.
+func (c *Client) Drift() (*DriftStatus, error) {
+	status := &DriftStatus{}
+	if err := c.do("GET", "/drift", nil, status); err != nil {
+		return nil, err
+	}
+	return status, nil
+}
+
+// CheckDrift checks the routes against the proxies in Toxiproxy now, repairing
+// any drift.
+func (c *Client) CheckDrift() (*DriftStatus, error) {
+	status := &DriftStatus{}
+	if err := c.do("POST", "/drift", nil, status); err != nil {
+		return nil, err
+	}
+	return status, nil
+}
+
+// Scenarios returns every scenario run, most recent first.
+func (c *Client) Scenarios() ([]ScenarioStatus, error) {
+	scenarios := []ScenarioStatus{}
+	if err := c.do("GET", "/scenarios", nil, &scenarios); err != nil {
+		return nil, err
+	}
+	return scenarios, nil
+}
+
+// StartScenario starts running the scenario.
+func (c *Client) StartScenario(scenario Scenario) (*ScenarioStatus, error) {
+	status := &ScenarioStatus{}
+	if err := c.do("POST", "/scenarios", scenario, status); err != nil {
+		return nil, err
+	}
+	return status, nil
+}
+
+// Scenario returns the progress of the scenario by id.
+func (c *Client) Scenario(id string) (*ScenarioStatus, error) {
+	status := &ScenarioStatus{}
+	if err := c.do("GET", "/scenarios/"+url.PathEscape(id), nil, status); err != nil {
+		return nil, err
+	}
+	return status, nil
+}
+
+// CancelScenario stops the scenario by id before its next step.
+func (c *Client) CancelScenario(id string) error {
+	return c.do("DELETE", "/scenarios/"+url.PathEscape(id), nil, nil)
+}
+
+// do the api request, encoding body and decoding the response into out when
+// they aren't nil. Error responses are returned as an *APIError.
+func (c *Client) do(method, path string, body, out interface{}) error {
+	var r io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		r = bytes.NewReader(b)
+	}
+	req, err := http.NewRequest(method, c.endpoint+path, r)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+
+	hc := c.HTTPClient
+	if hc == nil {
+		hc = http.DefaultClient
+	}
+	resp, err := hc.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		apiErr := &APIError{}
+		if json.Unmarshal(b, apiErr) != nil || apiErr.Message == "" {
+			apiErr.Status = http.StatusText(resp.StatusCode)
+			apiErr.Message = strings.TrimSpace(string(b))
+		}
+		apiErr.StatusCode = resp.StatusCode
+		return apiErr
+	}
+	if out == nil || len(b) == 0 {
+		return nil
+	}
+	return json.Unmarshal(b, out)
+}
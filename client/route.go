@@ -0,0 +1,193 @@
+package client
+
+import (
+	"net/url"
+	"time"
+
+	toxy "github.com/Shopify/toxiproxy/client"
+)
+
+// Route is a Shrike route and the Toxiproxy proxy its traffic goes through.
+// See the Shrike README for what each setting does.
+type Route struct {
+	Name     string            `json:"name,omitempty"`
+	Prefix   string            `json:"prefix"`
+	Match    string            `json:"match,omitempty"`
+	Host     string            `json:"host,omitempty"`
+	Upstream string            `json:"upstream,omitempty"`
+	Methods  []string          `json:"methods,omitempty"`
+	Headers  map[string]string `json:"headers,omitempty"`
+	Query    map[string]string `json:"query,omitempty"`
+	Priority int               `json:"priority,omitempty"`
+	TLS      *RouteTLS         `json:"tls,omitempty"`
+
+	// Proxy is the route's Toxiproxy proxy as of when the route was fetched.
+	Proxy  *toxy.Proxy `json:"-"`
+	client *Client
+}
+
+// RouteTLS holds the TLS settings for a route to an https upstream.
+type RouteTLS struct {
+	ServerName         string `json:"server_name,omitempty"`
+	CAFile             string `json:"ca_file,omitempty"`
+	InsecureSkipVerify bool   `json:"insecure_skip_verify,omitempty"`
+}
+
+type routeWithProxy struct {
+	Route Route       `json:"route"`
+	Toxy  *toxy.Proxy `json:"toxy"`
+}
+
+func (r *routeWithProxy) route(c *Client) *Route {
+	route := r.Route
+	route.Proxy = r.Toxy
+	route.client = c
+	return &route
+}
+
+// Toxic is a Toxiproxy toxic on a route along with its expiry, if it has one.
+type Toxic struct {
+	toxy.Toxic
+	ExpiresAt        *time.Time `json:"expires_at,omitempty"`
+	RemainingSeconds *float64   `json:"remaining_seconds,omitempty"`
+}
+
+// HTTPToxic is a fault applied at the HTTP level to requests on a route.
+type HTTPToxic struct {
+	Name       string          `json:"name"`
+	Type       string          `json:"type"`
+	Toxicity   float32         `json:"toxicity"`
+	Attributes toxy.Attributes `json:"attributes"`
+}
+
+// path of the route in the api.
+func (r *Route) path() string {
+	return "/routes/" + url.PathEscape(r.Name)
+}
+
+// Enable the route's proxy.
+func (r *Route) Enable() error {
+	return r.setEnabled(true)
+}
+
+// Disable the route's proxy, refusing connections through it.
+func (r *Route) Disable() error {
+	return r.setEnabled(false)
+}
+
+func (r *Route) setEnabled(enabled bool) error {
+	proxy := &toxy.Proxy{}
+	if err := r.client.do("POST", r.path(), map[string]bool{"enabled": enabled}, proxy); err != nil {
+		return err
+	}
+	r.Proxy = proxy
+	return nil
+}
+
+// Delete the route.
+func (r *Route) Delete() error {
+	return r.client.do("DELETE", r.path(), nil, nil)
+}
+
+// Toxics returns the toxics on the route.
+func (r *Route) Toxics() ([]Toxic, error) {
+	toxics := []Toxic{}
+	if err := r.client.do("GET", r.path()+"/toxics", nil, &toxics); err != nil {
+		return nil, err
+	}
+	return toxics, nil
+}
+
+// AddToxic adds a toxic to the route. The name defaults to <type>_<stream> and
+// the stream to downstream when empty.
+func (r *Route) AddToxic(name, typeName, stream string, toxicity float32, attrs toxy.Attributes) (*Toxic, error) {
+	return r.addToxic(name, typeName, stream, toxicity, attrs, "")
+}
+
+// AddTimedToxic adds a toxic to the route that is removed after d.
+func (r *Route) AddTimedToxic(name, typeName, stream string, toxicity float32, attrs toxy.Attributes, d time.Duration) (*Toxic, error) {
+	return r.addToxic(name, typeName, stream, toxicity, attrs, d.String())
+}
+
+func (r *Route) addToxic(name, typeName, stream string, toxicity float32, attrs toxy.Attributes, duration string) (*Toxic, error) {
+	body := struct {
+		toxy.Toxic
+		Duration string `json:"duration,omitempty"`
+	}{
+		Toxic: toxy.Toxic{
+			Name:       name,
+			Type:       typeName,
+			Stream:     stream,
+			Toxicity:   toxicity,
+			Attributes: attrs,
+		},
+		Duration: duration,
+	}
+	toxic := &Toxic{}
+	if err := r.client.do("POST", r.path()+"/toxics", body, toxic); err != nil {
+		return nil, err
+	}
+	return toxic, nil
+}
+
+// UpdateToxic changes the toxicity and attributes of the toxic by name.
+func (r *Route) UpdateToxic(name string, toxicity float32, attrs toxy.Attributes) (*Toxic, error) {
+	toxic := &Toxic{}
+	body := toxy.Toxic{Toxicity: toxicity, Attributes: attrs}
+	if err := r.client.do("POST", r.path()+"/toxics/"+url.PathEscape(name), body, toxic); err != nil {
+		return nil, err
+	}
+	return toxic, nil
+}
+
+// UpdateToxicAttributes changes the attributes of the toxic by name, keeping its
+// toxicity.
+func (r *Route) UpdateToxicAttributes(name string, attrs toxy.Attributes) (*Toxic, error) {
+	toxic := &Toxic{}
+	body := struct {
+		Attributes toxy.Attributes `json:"attributes"`
+	}{attrs}
+	if err := r.client.do("POST", r.path()+"/toxics/"+url.PathEscape(name), body, toxic); err != nil {
+		return nil, err
+	}
+	return toxic, nil
+}
+
+// RemoveToxic removes the toxic by name.
+func (r *Route) RemoveToxic(name string) error {
+	return r.client.do("DELETE", r.path()+"/toxics/"+url.PathEscape(name), nil, nil)
+}
+
+// HTTPToxics returns the HTTP toxics on the route.
+func (r *Route) HTTPToxics() ([]HTTPToxic, error) {
+	toxics := []HTTPToxic{}
+	if err := r.client.do("GET", r.path()+"/http-toxics", nil, &toxics); err != nil {
+		return nil, err
+	}
+	return toxics, nil
+}
+
+// AddHTTPToxic adds an HTTP toxic to the route. The name defaults to the type.
+func (r *Route) AddHTTPToxic(name, typeName string, toxicity float32, attrs toxy.Attributes) (*HTTPToxic, error) {
+	toxic := &HTTPToxic{}
+	body := HTTPToxic{Name: name, Type: typeName, Toxicity: toxicity, Attributes: attrs}
+	if err := r.client.do("POST", r.path()+"/http-toxics", body, toxic); err != nil {
+		return nil, err
+	}
+	return toxic, nil
+}
+
+// UpdateHTTPToxic changes the toxicity and attributes of the HTTP toxic by name.
+func (r *Route) UpdateHTTPToxic(name string, toxicity float32, attrs toxy.Attributes) (*HTTPToxic, error) {
+	toxic := &HTTPToxic{}
+	body := HTTPToxic{Toxicity: toxicity, Attributes: attrs}
+	if err := r.client.do("POST", r.path()+"/http-toxics/"+url.PathEscape(name), body, toxic); err != nil {
+		return nil, err
+	}
+	return toxic, nil
+}
+
+// RemoveHTTPToxic removes the HTTP toxic by name.
+func (r *Route) RemoveHTTPToxic(name string) error {
+	return r.client.do("DELETE", r.path()+"/http-toxics/"+url.PathEscape(name), nil, nil)
+}
@@ -0,0 +1,99 @@
+package client
+
+import (
+	"time"
+
+	toxy "github.com/Shopify/toxiproxy/client"
+)
+
+// Snapshot is the state of every route.
+type Snapshot struct {
+	Routes []RouteConfig `json:"routes"`
+}
+
+// RouteConfig is the full configuration of a route: its settings, whether its
+// proxy is enabled and the toxics on it.
+type RouteConfig struct {
+	Route
+	Enabled       bool                 `json:"enabled"`
+	Toxics        toxy.Toxics          `json:"toxics"`
+	HTTPToxics    []HTTPToxic          `json:"http_toxics,omitempty"`
+	ToxicExpiries map[string]time.Time `json:"toxic_expiries,omitempty"`
+}
+
+// ConfigStatus is the result of the last reload of the route config file.
+type ConfigStatus struct {
+	Time    time.Time  `json:"time"`
+	Success bool       `json:"success"`
+	Error   string     `json:"error,omitempty"`
+	Diff    ConfigDiff `json:"diff"`
+}
+
+// DriftStatus is the result of a check of the routes against the proxies in
+// Toxiproxy.
+type DriftStatus struct {
+	Time      time.Time `json:"time"`
+	Adopted   []string  `json:"adopted"`
+	Removed   []string  `json:"removed"`
+	Refreshed []string  `json:"refreshed"`
+	Ignored   []string  `json:"ignored"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// ConfigDiff holds the changes a reload applied to the routes.
+type ConfigDiff struct {
+	Added   []string    `json:"added"`
+	Removed []string    `json:"removed"`
+	Changed []RouteDiff `json:"changed"`
+}
+
+// RouteDiff holds the changes a reload made to an existing route.
+type RouteDiff struct {
+	Name              string   `json:"name"`
+	Enabled           *bool    `json:"enabled,omitempty"`
+	ToxicsAdded       []string `json:"toxics_added,omitempty"`
+	ToxicsUpdated     []string `json:"toxics_updated,omitempty"`
+	ToxicsRemoved     []string `json:"toxics_removed,omitempty"`
+	HTTPToxicsUpdated bool     `json:"http_toxics_updated,omitempty"`
+}
+
+// Scenario is a timeline of changes for Shrike to make to the routes.
+type Scenario struct {
+	Name  string         `json:"name,omitempty"`
+	Steps []ScenarioStep `json:"steps"`
+}
+
+// ScenarioStep is a change made At an offset, such as "60s", from the start
+// of the scenario. See the Shrike README for the actions.
+type ScenarioStep struct {
+	At        string     `json:"at"`
+	Action    string     `json:"action"`
+	Route     string     `json:"route,omitempty"`
+	Toxic     *StepToxic `json:"toxic,omitempty"`
+	HTTPToxic *HTTPToxic `json:"http_toxic,omitempty"`
+}
+
+// StepToxic is a toxic to add or remove in a scenario step, with an optional
+// Duration, such as "5m", after which it is removed.
+type StepToxic struct {
+	toxy.Toxic
+	Duration string `json:"duration,omitempty"`
+}
+
+// ScenarioStatus is the progress of a scenario run.
+type ScenarioStatus struct {
+	ID         string       `json:"id"`
+	Name       string       `json:"name,omitempty"`
+	State      string       `json:"state"`
+	StartedAt  time.Time    `json:"started_at"`
+	FinishedAt *time.Time   `json:"finished_at,omitempty"`
+	Steps      []StepStatus `json:"steps"`
+}
+
+// StepStatus is the progress of a step in a scenario run.
+type StepStatus struct {
+	ScenarioStep
+	State string     `json:"state"`
+	RanAt *time.Time `json:"ran_at,omitempty"`
+	Error string     `json:"error,omitempty"`
+}
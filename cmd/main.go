@@ -1,8 +1,14 @@
 package main
 
 import (
+	"context"
 	"flag"
 	_ "net/http/pprof"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/pressly/lg"
 	"github.com/richardbolt/shrike/api"
@@ -14,6 +20,30 @@ var host string
 var port int
 var apiPort int
 var upstreamURL string
+var upstreamHosts string
+var toxyExternal bool
+var toxyAddress string
+var toxyAPIPort int
+var toxyListenHost string
+var toxyPathSeparator string
+var toxyEphemeralPorts bool
+var toxyPortMin int
+var toxyPortMax int
+var tlsCert string
+var tlsKey string
+var tlsSelfSigned bool
+var tlsCAOut string
+var stateFile string
+var configFile string
+var watchConfig bool
+var accessLog string
+var accessLogFormat string
+var apiTokens string
+var apiReadTokens string
+var apiClientCA string
+var drainTimeout time.Duration
+var driftInterval time.Duration
+var driftAdoptNamed bool
 
 func main() {
 	// Redirect stdout to logrus.
@@ -21,22 +51,144 @@ func main() {
 	lg.RedirectStdlogOutput(logger)
 	lg.DefaultLogger = logger
 
-	cfg := cfg.New()
-	flag.StringVar(&host, "host", cfg.Host, "Host for The Shrike to listen on")
-	flag.IntVar(&port, "port", cfg.Port, "Port for The Shrike to listen on")
-	flag.IntVar(&apiPort, "apiport", cfg.APIPort, "Port for The Shrike's API to listen on")
-	flag.StringVar(&upstreamURL, "upstream", cfg.UpstreamURL, "Upstream URL to forward traffic to")
+	env := cfg.New()
+	flag.StringVar(&host, "host", env.Host, "Host for The Shrike to listen on")
+	flag.IntVar(&port, "port", env.Port, "Port for The Shrike to listen on")
+	flag.IntVar(&apiPort, "apiport", env.APIPort, "Port for The Shrike's API to listen on")
+	flag.StringVar(&upstreamURL, "upstream", env.UpstreamURL, "Upstream URL to forward traffic to")
+	flag.StringVar(&upstreamHosts, "upstream-hosts", env.UpstreamHosts, "Comma separated host=url upstreams for requests to those hosts")
+	flag.BoolVar(&toxyExternal, "toxy-external", env.ToxyExternal, "Use the Toxiproxy at -toxy-address rather than running one")
+	flag.StringVar(&toxyAddress, "toxy-address", env.ToxyAddress, "Host of the Toxiproxy API, which the embedded Toxiproxy listens on")
+	flag.IntVar(&toxyAPIPort, "toxy-api-port", env.ToxyAPIPort, "Port of the Toxiproxy API")
+	flag.StringVar(&toxyListenHost, "toxy-listen-host", env.ToxyListenHost, "Host Toxiproxy proxies listen on and are reached at, defaults to -toxy-address")
+	flag.StringVar(&toxyPathSeparator, "toxy-path-separator", env.ToxyPathSeparator, "Separator to replace / with in Toxiproxy proxy names for route prefixes")
+	flag.BoolVar(&toxyEphemeralPorts, "toxy-ephemeral-ports", env.ToxyEphemeralPorts, "Give Toxiproxy proxies ports picked by the OS rather than from the port range")
+	flag.IntVar(&toxyPortMin, "toxy-port-min", env.ToxyPortMin, "Lowest port to give Toxiproxy proxies")
+	flag.IntVar(&toxyPortMax, "toxy-port-max", env.ToxyPortMax, "Highest port to give Toxiproxy proxies")
+	flag.StringVar(&tlsCert, "tls-cert", env.TLSCert, "TLS certificate file for the proxy and API listeners")
+	flag.StringVar(&tlsKey, "tls-key", env.TLSKey, "TLS key file for the proxy and API listeners")
+	flag.BoolVar(&tlsSelfSigned, "tls-self-signed", env.TLSSelfSigned, "Generate a self-signed CA and certificate for the proxy and API listeners")
+	flag.StringVar(&tlsCAOut, "tls-ca-out", env.TLSCAOut, "File to write the generated self-signed CA certificate to")
+	flag.StringVar(&stateFile, "state-file", env.StateFile, "File to persist routes and toxics to across restarts")
+	flag.StringVar(&configFile, "config", env.ConfigFile, "YAML or JSON file of routes and toxics to load at startup")
+	flag.BoolVar(&watchConfig, "watch-config", env.WatchConfig, "Reload the route config file when it changes")
+	flag.StringVar(&accessLog, "access-log", env.AccessLog, "File to write the proxy access log to, - for stdout")
+	flag.StringVar(&accessLogFormat, "access-log-format", env.AccessLogFormat, "Access log format: json, common or combined")
+	flag.StringVar(&apiTokens, "api-tokens", env.APITokens, "Comma separated bearer tokens that can use the whole api")
+	flag.StringVar(&apiReadTokens, "api-read-tokens", env.APIReadTokens, "Comma separated bearer tokens that can only make GET requests to the api")
+	flag.StringVar(&apiClientCA, "api-client-ca", env.APIClientCA, "CA file for client certificates that can use the whole api")
+	flag.DurationVar(&drainTimeout, "drain-timeout", env.DrainTimeout, "How long to wait for requests in flight to finish when shutting down")
+	flag.DurationVar(&driftInterval, "drift-interval", env.DriftInterval, "How often to check the routes against the proxies in Toxiproxy, 0 to only check at startup")
+	flag.BoolVar(&driftAdoptNamed, "drift-adopt-named", env.DriftAdoptNamed, "Adopt any proxy without a route that is named after a prefix, not just The Shrike's own")
 	flag.Parse()
 
-	server := api.New(api.Config{
+	hosts, err := cfg.ParseUpstreamHosts(upstreamHosts)
+	if err != nil {
+		log.Fatalf("Invalid upstream hosts: %s", err)
+	}
+
+	var routes []api.RouteConfig
+	if configFile != "" {
+		if routes, err = cfg.LoadRoutes(configFile); err != nil {
+			log.Fatalf("Invalid route config: %s", err)
+		}
+	}
+
+	var persister api.Persister
+	if stateFile != "" {
+		persister = api.NewFilePersister(stateFile)
+	}
+
+	server, err := api.New(api.Config{
 		Host:              host,
 		Port:              port,
 		APIPort:           apiPort,
-		ToxyAddress:       "127.0.0.1",
-		ToxyAPIPort:       8474,
-		ToxyPathSeparator: "__",
+		ToxyAddress:       toxyAddress,
+		ToxyListenHost:    toxyListenHost,
+		ToxyExternal:      toxyExternal,
+		ToxyAPIPort:       toxyAPIPort,
+		ToxyPathSeparator: toxyPathSeparator,
+		ToxyPortMin:       toxyPortMin,
+		ToxyPortMax:       toxyPortMax,
 		UpstreamURL:       upstreamURL,
+		UpstreamHosts:     hosts,
+		TLSCert:           tlsCert,
+		TLSKey:            tlsKey,
+		TLSSelfSigned:     tlsSelfSigned,
+		TLSCAOut:          tlsCAOut,
+		Persister:         persister,
+		AccessLog:         accessLog,
+		AccessLogFormat:   accessLogFormat,
+		APITokens:         splitList(apiTokens),
+		APIReadTokens:     splitList(apiReadTokens),
+		APIClientCA:       apiClientCA,
+		Routes:            routes,
+
+		ToxyEphemeralPorts: toxyEphemeralPorts,
+		DriftInterval:      driftInterval,
+		DriftAdoptNamed:    driftAdoptNamed,
 	})
+	if err != nil {
+		log.Fatalf("Invalid config: %s", err)
+	}
+
+	if err := server.Start(context.Background()); err != nil {
+		log.Fatalf("Failed to start: %s", err)
+	}
+
+	// Reload the route config file on SIGHUP and optionally when it changes, once
+	// Start has loaded it.
+	if configFile != "" {
+		reload := func() {
+			server.ReloadRoutes(func() ([]api.RouteConfig, error) {
+				return cfg.LoadRoutes(configFile)
+			})
+		}
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		go func() {
+			for range hup {
+				reload()
+			}
+		}()
+		if watchConfig {
+			if err := cfg.WatchFile(configFile, reload); err != nil {
+				log.Fatalf("Could not watch the route config file: %s", err)
+			}
+		}
+	}
+
+	// Drain requests in flight on SIGTERM or an interrupt.
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGTERM, os.Interrupt)
+	errc := make(chan error, 1)
+	go func() {
+		errc <- server.Wait()
+	}()
+	select {
+	case err := <-errc:
+		log.Fatalf("Server failed: %s", err)
+	case sig := <-stop:
+		log.WithFields(log.Fields{
+			"signal":  sig,
+			"timeout": drainTimeout,
+		}).Info("Shutting down")
+		ctx, cancel := context.WithTimeout(context.Background(), drainTimeout)
+		defer cancel()
+		if err := server.Shutdown(ctx); err != nil {
+			log.Fatalf("Failed to shut down cleanly: %s", err)
+		}
+		log.Info("Shut down")
+	}
+}
 
-	server.Listen()
+// splitList splits a comma separated list, dropping empty entries.
+func splitList(s string) []string {
+	list := []string{}
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
 }
@@ -0,0 +1,438 @@
+// Command shrike-cli manages The Shrike's routes and toxics through its api.
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	toxy "github.com/Shopify/toxiproxy/client"
+	"github.com/richardbolt/shrike/client"
+)
+
+const usage = `Usage: shrike-cli [options] <command> [arguments]
+
+Commands:
+  list                                  List the routes and their toxics
+  route add <path> [route options]      Add a route for the path
+  route delete <route>                  Delete a route
+  route enable <route>                  Enable a route's proxy
+  route disable <route>                 Disable a route's proxy
+  toxic list <route>                    List the toxics on a route
+  toxic add <route> [toxic options]     Add a toxic to a route
+  toxic update <route> [toxic options]  Update a toxic on a route
+  toxic remove <route> -n <name>        Remove a toxic from a route
+  reset                                 Remove every toxic and enable every route
+
+A <route> is a route's path prefix, such as /orders/v1, or its name.
+
+Options:
+`
+
+var apiAddr string
+var token string
+var jsonOutput bool
+
+// out is where the results are printed.
+var out io.Writer = os.Stdout
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+		flag.PrintDefaults()
+	}
+	flag.StringVar(&apiAddr, "api", envOr("SHRIKE_API", "localhost:8475"), "Address of The Shrike's api")
+	flag.StringVar(&token, "token", os.Getenv("SHRIKE_TOKEN"), "Bearer token for the api")
+	flag.BoolVar(&jsonOutput, "json", false, "Print JSON rather than tables")
+	flag.Parse()
+
+	c := client.NewClient(apiAddr)
+	c.Token = token
+	if err := run(c, flag.Args()); err != nil && err != flag.ErrHelp {
+		fmt.Fprintln(os.Stderr, "shrike-cli:", err)
+		os.Exit(1)
+	}
+}
+
+func run(c *client.Client, args []string) error {
+	if len(args) == 0 {
+		flag.Usage()
+		return errors.New("no command given")
+	}
+	switch args[0] {
+	case "list", "ls":
+		return list(c)
+	case "reset":
+		return c.ResetState()
+	case "route":
+		if len(args) < 2 {
+			return errors.New("route needs a subcommand: add, delete, enable or disable")
+		}
+		return route(c, args[1], args[2:])
+	case "toxic":
+		if len(args) < 2 {
+			return errors.New("toxic needs a subcommand: list, add, update or remove")
+		}
+		return toxic(c, args[1], args[2:])
+	}
+	return fmt.Errorf("unknown command %q", args[0])
+}
+
+// list the routes with their toxics.
+func list(c *client.Client) error {
+	routes, err := c.Routes()
+	if err != nil {
+		return err
+	}
+	names := []string{}
+	for name := range routes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if jsonOutput {
+		list := []*client.Route{}
+		for _, name := range names {
+			list = append(list, routes[name])
+		}
+		return printJSON(list)
+	}
+
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tPREFIX\tMATCH\tENABLED\tLISTEN\tTOXICS")
+	for _, name := range names {
+		r := routes[name]
+		enabled, listen, toxics := false, "", []string{}
+		if r.Proxy != nil {
+			enabled, listen = r.Proxy.Enabled, r.Proxy.Listen
+			for _, t := range r.Proxy.ActiveToxics {
+				toxics = append(toxics, t.Name)
+			}
+		}
+		match := r.Match
+		if match == "" {
+			match = "prefix"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%t\t%s\t%s\n", name, r.Prefix, match, enabled, listen, orNone(strings.Join(toxics, ",")))
+	}
+	return w.Flush()
+}
+
+// route runs the route subcommand.
+func route(c *client.Client, cmd string, args []string) error {
+	if cmd == "add" {
+		return addRoute(c, args)
+	}
+	fs := flag.NewFlagSet("route "+cmd, flag.ContinueOnError)
+	pos, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(pos) != 1 {
+		return fmt.Errorf("route %s needs a route", cmd)
+	}
+	r, err := findRoute(c, pos[0])
+	if err != nil {
+		return err
+	}
+	switch cmd {
+	case "delete", "rm":
+		err = r.Delete()
+	case "enable":
+		err = r.Enable()
+	case "disable":
+		err = r.Disable()
+	default:
+		return fmt.Errorf("unknown route subcommand %q", cmd)
+	}
+	if err != nil {
+		return err
+	}
+	return printDone(r.Name, cmd)
+}
+
+func addRoute(c *client.Client, args []string) error {
+	fs := flag.NewFlagSet("route add", flag.ContinueOnError)
+	r := client.Route{}
+	var methods, headers, query listFlag
+	fs.StringVar(&r.Name, "name", "", "Name of the route, needed when routes share a prefix")
+	fs.StringVar(&r.Match, "match", "", "How the path is matched: prefix, exact, glob or regex")
+	fs.StringVar(&r.Host, "host", "", "Request host to match")
+	fs.StringVar(&r.Upstream, "upstream", "", "Upstream URL for the route")
+	fs.IntVar(&r.Priority, "priority", 0, "Priority among routes sharing a prefix")
+	fs.Var(&methods, "method", "Request method to match, can be repeated")
+	fs.Var(&headers, "header", "Request header to match as name=value, can be repeated")
+	fs.Var(&query, "query", "Query parameter to match as name=value, can be repeated")
+	pos, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(pos) != 1 {
+		return errors.New("route add needs a path")
+	}
+	r.Prefix = pos[0]
+	r.Methods = methods
+	if r.Headers, err = headers.pairs(); err != nil {
+		return err
+	}
+	if r.Query, err = query.pairs(); err != nil {
+		return err
+	}
+
+	created, err := c.CreateRoute(r)
+	if err != nil {
+		return err
+	}
+	if jsonOutput {
+		return printJSON(created)
+	}
+	fmt.Fprintf(out, "Added route %s for %s listening on %s\n", created.Name, created.Prefix, created.Proxy.Listen)
+	return nil
+}
+
+// toxic runs the toxic subcommand.
+func toxic(c *client.Client, cmd string, args []string) error {
+	fs := flag.NewFlagSet("toxic "+cmd, flag.ContinueOnError)
+	var attrs listFlag
+	name := fs.String("n", "", "Name of the toxic")
+	typeName := fs.String("t", "", "Type of the toxic, such as latency")
+	stream := fs.String("s", "", "Stream, upstream or downstream")
+	toxicity := fs.Float64("tox", 1, "Toxicity of the toxic, from 0 to 1, kept by updates unless given")
+	duration := fs.Duration("d", 0, "Remove the toxic after this long, such as 5m")
+	fs.Var(&attrs, "a", "Attribute as key=value, can be repeated")
+	pos, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(pos) != 1 {
+		return fmt.Errorf("toxic %s needs a route", cmd)
+	}
+	r, err := findRoute(c, pos[0])
+	if err != nil {
+		return err
+	}
+	attributes, err := attrs.attributes()
+	if err != nil {
+		return err
+	}
+
+	switch cmd {
+	case "list", "ls":
+		toxics, err := r.Toxics()
+		if err != nil {
+			return err
+		}
+		return printToxics(toxics)
+	case "add":
+		if *typeName == "" {
+			return errors.New("toxic add needs a type, -t")
+		}
+		var t *client.Toxic
+		if *duration > 0 {
+			t, err = r.AddTimedToxic(*name, *typeName, *stream, float32(*toxicity), attributes, *duration)
+		} else {
+			t, err = r.AddToxic(*name, *typeName, *stream, float32(*toxicity), attributes)
+		}
+		if err != nil {
+			return err
+		}
+		return printToxics([]client.Toxic{*t})
+	case "update":
+		if *name == "" {
+			return errors.New("toxic update needs a name, -n")
+		}
+		var t *client.Toxic
+		if isSet(fs, "tox") {
+			t, err = r.UpdateToxic(*name, float32(*toxicity), attributes)
+		} else {
+			t, err = r.UpdateToxicAttributes(*name, attributes)
+		}
+		if err != nil {
+			return err
+		}
+		return printToxics([]client.Toxic{*t})
+	case "remove", "rm", "delete":
+		if *name == "" {
+			return errors.New("toxic remove needs a name, -n")
+		}
+		if err := r.RemoveToxic(*name); err != nil {
+			return err
+		}
+		return printDone(*name, "removed")
+	}
+	return fmt.Errorf("unknown toxic subcommand %q", cmd)
+}
+
+// findRoute returns the route by prefix or name, refusing a prefix that several
+// routes share.
+func findRoute(c *client.Client, ref string) (*client.Route, error) {
+	if !strings.HasPrefix(ref, "/") {
+		return c.Route(ref)
+	}
+	routes, err := c.Routes()
+	if err != nil {
+		return nil, err
+	}
+	found := []*client.Route{}
+	for _, r := range routes {
+		if r.Prefix == ref {
+			found = append(found, r)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return c.Route(ref)
+	case 1:
+		return found[0], nil
+	}
+	names := []string{}
+	for _, r := range found {
+		names = append(names, r.Name)
+	}
+	sort.Strings(names)
+	return nil, fmt.Errorf("routes %s share the prefix %s, use a name", strings.Join(names, ", "), ref)
+}
+
+func printToxics(toxics []client.Toxic) error {
+	if jsonOutput {
+		return printJSON(toxics)
+	}
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tTYPE\tSTREAM\tTOXICITY\tATTRIBUTES\tEXPIRES")
+	for _, t := range toxics {
+		keys := []string{}
+		for k := range t.Attributes {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		attrs := []string{}
+		for _, k := range keys {
+			attrs = append(attrs, fmt.Sprintf("%s=%v", k, t.Attributes[k]))
+		}
+		expires := ""
+		if t.RemainingSeconds != nil {
+			expires = "in " + (time.Duration(*t.RemainingSeconds) * time.Second).String()
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%g\t%s\t%s\n", t.Name, t.Type, t.Stream, t.Toxicity, orNone(strings.Join(attrs, " ")), orNone(expires))
+	}
+	return w.Flush()
+}
+
+func printDone(name, action string) error {
+	if jsonOutput {
+		return printJSON(map[string]string{"name": name, "result": action})
+	}
+	fmt.Fprintf(out, "%s: %s\n", name, action)
+	return nil
+}
+
+func printJSON(v interface{}) error {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(out, string(b))
+	return nil
+}
+
+func orNone(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+func envOr(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
+
+// parseArgs parses flags given before, between or after the positional
+// arguments. Everything after -- is a positional argument.
+func parseArgs(fs *flag.FlagSet, args []string) ([]string, error) {
+	pos := []string{}
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		rest := fs.Args()
+		if n := len(args) - len(rest); n > 0 && args[n-1] == "--" {
+			return append(pos, rest...), nil
+		}
+		if len(rest) == 0 {
+			return pos, nil
+		}
+		pos = append(pos, rest[0])
+		args = rest[1:]
+	}
+}
+
+// isSet reports whether the flag by name was given.
+func isSet(fs *flag.FlagSet, name string) bool {
+	set := false
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+// listFlag is a flag that can be given more than once.
+type listFlag []string
+
+func (l *listFlag) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *listFlag) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
+
+// pairs returns the name=value pairs as a map.
+func (l listFlag) pairs() (map[string]string, error) {
+	if len(l) == 0 {
+		return nil, nil
+	}
+	m := map[string]string{}
+	for _, v := range l {
+		kv := strings.SplitN(v, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return nil, fmt.Errorf("%q is not a name=value pair", v)
+		}
+		m[kv[0]] = kv[1]
+	}
+	return m, nil
+}
+
+// attributes returns the key=value pairs as toxic attributes, with numbers and
+// booleans given as such.
+func (l listFlag) attributes() (toxy.Attributes, error) {
+	pairs, err := l.pairs()
+	if err != nil || pairs == nil {
+		return nil, err
+	}
+	attrs := toxy.Attributes{}
+	for k, v := range pairs {
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+			attrs[k] = n
+		} else if f, err := strconv.ParseFloat(v, 64); err == nil {
+			attrs[k] = f
+		} else if b, err := strconv.ParseBool(v); err == nil {
+			attrs[k] = b
+		} else {
+			attrs[k] = v
+		}
+	}
+	return attrs, nil
+}
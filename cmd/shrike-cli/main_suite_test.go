@@ -0,0 +1,13 @@
+package main
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestShrikeCLI(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Shrike CLI Suite")
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/richardbolt/shrike/client"
+)
+
+const orders = `{"route": {"name": "__orders", "prefix": "/orders"}, "toxy": {"name": "__orders", "listen": "127.0.0.1:10000", "enabled": true, "toxics": [{"name": "slow", "type": "latency"}]}}`
+
+// request is a request made to the fake api.
+type request struct {
+	method, path, body string
+}
+
+var _ = Describe("parseArgs", func() {
+	It("separates the flags from the positional arguments", func() {
+		for _, c := range []struct {
+			args []string
+			pos  []string
+			name string
+		}{
+			{[]string{"/orders"}, []string{"/orders"}, ""},
+			{[]string{"/orders", "-n", "slow"}, []string{"/orders"}, "slow"},
+			{[]string{"-n", "slow", "/orders"}, []string{"/orders"}, "slow"},
+			{[]string{"/a", "-n", "slow", "/b"}, []string{"/a", "/b"}, "slow"},
+			{[]string{"-n", "slow", "--", "-odd"}, []string{"-odd"}, "slow"},
+			{[]string{"/a", "--", "/b", "-n", "slow"}, []string{"/a", "/b", "-n", "slow"}, ""},
+			{[]string{"--"}, []string{}, ""},
+		} {
+			fs := flag.NewFlagSet("test", flag.ContinueOnError)
+			name := fs.String("n", "", "")
+			pos, err := parseArgs(fs, c.args)
+			Expect(err).NotTo(HaveOccurred(), strings.Join(c.args, " "))
+			Expect(pos).To(Equal(c.pos), strings.Join(c.args, " "))
+			Expect(*name).To(Equal(c.name), strings.Join(c.args, " "))
+		}
+	})
+
+	It("returns an error for unknown flags", func() {
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		fs.SetOutput(ioutil.Discard)
+		_, err := parseArgs(fs, []string{"/orders", "-bogus"})
+		Expect(err).To(HaveOccurred())
+	})
+})
+
+var _ = Describe("run", func() {
+	var (
+		api      *httptest.Server
+		c        *client.Client
+		requests []request
+		output   *bytes.Buffer
+	)
+
+	BeforeEach(func() {
+		requests = nil
+		api = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			body, _ := ioutil.ReadAll(req.Body)
+			requests = append(requests, request{req.Method, req.URL.EscapedPath(), string(body)})
+			w.Header().Set("Content-Type", "application/json")
+			switch {
+			case req.Method == "GET" && req.URL.Path == "/routes":
+				w.Write([]byte(`{"/orders": ` + orders + `}`))
+			case req.Method == "GET" && strings.HasSuffix(req.URL.Path, "/toxics"):
+				w.Write([]byte(`[{"name": "slow", "type": "latency", "toxicity": 1}]`))
+			case req.Method == "GET" && strings.HasPrefix(req.URL.Path, "/routes/"):
+				w.Write([]byte(orders))
+			case req.Method == "POST" && strings.Contains(req.URL.Path, "/toxics"):
+				w.Write([]byte(`{"name": "slow", "type": "latency", "toxicity": 1}`))
+			case req.Method == "POST":
+				w.Write([]byte(`{"name": "__orders", "listen": "127.0.0.1:10000", "enabled": true}`))
+			default:
+				w.WriteHeader(http.StatusNoContent)
+			}
+		}))
+		c = client.NewClient(api.URL)
+		output = &bytes.Buffer{}
+		out = output
+	})
+
+	AfterEach(func() {
+		api.Close()
+	})
+
+	It("makes the api request for each command", func() {
+		for _, cmd := range []struct {
+			args []string
+			last request
+		}{
+			{[]string{"reset"}, request{"POST", "/routes/reset", ""}},
+			{[]string{"list"}, request{"GET", "/routes", ""}},
+			{[]string{"route", "add", "/orders", "-method", "POST", "-header", "X-Tenant=canary"},
+				request{"POST", "/routes", `{"prefix":"/orders","methods":["POST"],"headers":{"X-Tenant":"canary"}}`}},
+			{[]string{"route", "delete", "/orders"}, request{"DELETE", "/routes/__orders", ""}},
+			{[]string{"route", "enable", "__orders"}, request{"POST", "/routes/__orders", `{"enabled":true}`}},
+			{[]string{"route", "disable", "/orders"}, request{"POST", "/routes/__orders", `{"enabled":false}`}},
+			{[]string{"toxic", "list", "/orders"}, request{"GET", "/routes/__orders/toxics", ""}},
+			{[]string{"toxic", "add", "/orders", "-t", "latency", "-a", "latency=500", "-d", "5m"},
+				request{"POST", "/routes/__orders/toxics", `{"name":"","type":"latency","toxicity":1,"attributes":{"latency":500},"duration":"5m0s"}`}},
+			{[]string{"toxic", "update", "/orders", "-n", "slow", "-a", "latency=200"},
+				request{"POST", "/routes/__orders/toxics/slow", `{"attributes":{"latency":200}}`}},
+			{[]string{"toxic", "update", "/orders", "-n", "slow", "-tox", "0.5"},
+				request{"POST", "/routes/__orders/toxics/slow", `{"name":"","type":"","toxicity":0.5,"attributes":null}`}},
+			{[]string{"toxic", "remove", "/orders", "-n", "slow"}, request{"DELETE", "/routes/__orders/toxics/slow", ""}},
+		} {
+			requests = nil
+			Expect(run(c, cmd.args)).To(Succeed(), strings.Join(cmd.args, " "))
+			Expect(requests).NotTo(BeEmpty(), strings.Join(cmd.args, " "))
+			last := requests[len(requests)-1]
+			Expect(last.method).To(Equal(cmd.last.method), strings.Join(cmd.args, " "))
+			Expect(last.path).To(Equal(cmd.last.path), strings.Join(cmd.args, " "))
+			if cmd.last.body == "" {
+				Expect(last.body).To(BeEmpty(), strings.Join(cmd.args, " "))
+			} else {
+				Expect(last.body).To(MatchJSON(cmd.last.body), strings.Join(cmd.args, " "))
+			}
+		}
+	})
+
+	It("prints the routes", func() {
+		Expect(run(c, []string{"list"})).To(Succeed())
+		Expect(output.String()).To(MatchRegexp(`__orders\s+/orders\s+prefix\s+true\s+127.0.0.1:10000\s+slow`))
+	})
+
+	It("refuses commands that are missing or incomplete", func() {
+		for _, args := range [][]string{
+			{"bogus"},
+			{"route"},
+			{"route", "bogus", "/orders"},
+			{"route", "delete"},
+			{"toxic"},
+			{"toxic", "add", "/orders"},
+			{"toxic", "update", "/orders", "-a", "latency=1"},
+			{"toxic", "remove", "/orders"},
+		} {
+			Expect(run(c, args)).NotTo(Succeed(), strings.Join(args, " "))
+		}
+	})
+})
@@ -0,0 +1,223 @@
+// Package shriketest runs a Shrike server in-process for chaos testing from go
+// test, in the style of net/http/httptest.
+//
+//	func TestOrdersAreSlow(t *testing.T) {
+//		h := shriketest.New(t, ordersHandler)
+//		h.Route("/orders").Latency(200 * time.Millisecond)
+//		resp, err := http.Get(h.URL + "/orders/42")
+//		...
+//	}
+//
+// Everything is stopped by t.Cleanup when the test finishes, so the package
+// needs Go 1.14 or later.
+package shriketest
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	toxy "github.com/Shopify/toxiproxy/client"
+	"github.com/richardbolt/shrike/api"
+	"github.com/richardbolt/shrike/client"
+)
+
+// Harness is a Shrike server and the upstream it proxies to, listening on
+// ephemeral ports on the loopback interface.
+type Harness struct {
+	// URL of the proxy, such as http://127.0.0.1:51234.
+	URL string
+	// Upstream is the server requests not failed by a toxic end up at.
+	Upstream *httptest.Server
+	// Client for the Shrike api.
+	Client *client.Client
+	// Server is the Shrike server itself.
+	Server *api.ShrikeServer
+	t      testing.TB
+}
+
+// New starts a Shrike server proxying to an httptest server for upstream.
+func New(t testing.TB, upstream http.Handler) *Harness {
+	t.Helper()
+	u := httptest.NewServer(upstream)
+	t.Cleanup(u.Close)
+
+	// Every port is picked by the OS as it's listened on.
+	s, err := api.New(api.Config{
+		Host:               "127.0.0.1",
+		ToxyAddress:        "127.0.0.1",
+		ToxyPathSeparator:  "__",
+		UpstreamURL:        u.URL,
+		ToxyEphemeralPorts: true,
+	})
+	if err != nil {
+		t.Fatalf("shriketest: creating the Shrike server: %s", err)
+	}
+	if err := s.Start(context.Background()); err != nil {
+		t.Fatalf("shriketest: starting the Shrike server: %s", err)
+	}
+	t.Cleanup(func() {
+		s.Close()
+	})
+
+	return &Harness{
+		URL:      "http://" + s.Addr(),
+		Upstream: u,
+		Client:   client.NewClient(s.APIAddr()),
+		Server:   s,
+		t:        t,
+	}
+}
+
+// Route returns the route for the path prefix, adding it if it doesn't exist.
+func (h *Harness) Route(prefix string) *Route {
+	h.t.Helper()
+	r, err := h.Client.Route(prefix)
+	if client.IsNotFound(err) {
+		r, err = h.Client.CreateRoute(client.Route{Prefix: prefix})
+	}
+	if err != nil {
+		h.t.Fatalf("shriketest: getting the route for %s: %s", prefix, err)
+	}
+	return &Route{Route: r, t: h.t}
+}
+
+// Reset removes every toxic and enables every route.
+func (h *Harness) Reset() {
+	h.t.Helper()
+	if err := h.Client.ResetState(); err != nil {
+		h.t.Fatalf("shriketest: resetting: %s", err)
+	}
+}
+
+// Route is a route with helpers for adding faults to it. The helpers fail the
+// test on error and return the route so they can be chained. Adding a fault
+// of a type the route already has replaces it.
+type Route struct {
+	*client.Route
+	t testing.TB
+}
+
+// Latency delays responses by d.
+func (r *Route) Latency(d time.Duration) *Route {
+	r.t.Helper()
+	return r.Toxic("latency", toxy.Attributes{"latency": millis(d)})
+}
+
+// Bandwidth limits responses to kbps kilobytes per second.
+func (r *Route) Bandwidth(kbps int) *Route {
+	r.t.Helper()
+	return r.Toxic("bandwidth", toxy.Attributes{"rate": kbps})
+}
+
+// Timeout stops responses and closes the connection after d, or never
+// closes it when d is 0.
+func (r *Route) Timeout(d time.Duration) *Route {
+	r.t.Helper()
+	return r.Toxic("timeout", toxy.Attributes{"timeout": millis(d)})
+}
+
+// Toxic adds a Toxiproxy toxic of the type on the downstream, named after
+// its type.
+func (r *Route) Toxic(typeName string, attrs toxy.Attributes) *Route {
+	r.t.Helper()
+	toxics, err := r.Toxics()
+	if err != nil {
+		r.t.Fatalf("shriketest: getting the toxics on %s: %s", r.Prefix, err)
+	}
+	exists := false
+	for _, t := range toxics {
+		exists = exists || t.Name == typeName
+	}
+	if exists {
+		_, err = r.UpdateToxic(typeName, 1, attrs)
+	} else {
+		_, err = r.AddToxic(typeName, typeName, "", 1, attrs)
+	}
+	if err != nil {
+		r.t.Fatalf("shriketest: setting the %s toxic on %s: %s", typeName, r.Prefix, err)
+	}
+	return r
+}
+
+// Status responds to requests with the status code instead of forwarding them.
+func (r *Route) Status(code int) *Route {
+	r.t.Helper()
+	return r.HTTPToxic(api.HTTPToxicStatus, toxy.Attributes{"status_code": code})
+}
+
+// Abort resets client connections instead of forwarding requests.
+func (r *Route) Abort() *Route {
+	r.t.Helper()
+	return r.HTTPToxic(api.HTTPToxicAbort, nil)
+}
+
+// HTTPToxic adds an HTTP toxic of the type, named after its type.
+func (r *Route) HTTPToxic(typeName string, attrs toxy.Attributes) *Route {
+	r.t.Helper()
+	toxics, err := r.HTTPToxics()
+	if err != nil {
+		r.t.Fatalf("shriketest: getting the http toxics on %s: %s", r.Prefix, err)
+	}
+	exists := false
+	for _, t := range toxics {
+		exists = exists || t.Name == typeName
+	}
+	if exists {
+		_, err = r.UpdateHTTPToxic(typeName, 1, attrs)
+	} else {
+		_, err = r.AddHTTPToxic(typeName, typeName, 1, attrs)
+	}
+	if err != nil {
+		r.t.Fatalf("shriketest: setting the %s http toxic on %s: %s", typeName, r.Prefix, err)
+	}
+	return r
+}
+
+// Down disables the route, refusing connections through it.
+func (r *Route) Down() *Route {
+	r.t.Helper()
+	if err := r.Disable(); err != nil {
+		r.t.Fatalf("shriketest: disabling %s: %s", r.Prefix, err)
+	}
+	return r
+}
+
+// Up enables the route again.
+func (r *Route) Up() *Route {
+	r.t.Helper()
+	if err := r.Enable(); err != nil {
+		r.t.Fatalf("shriketest: enabling %s: %s", r.Prefix, err)
+	}
+	return r
+}
+
+// Clear removes the route's toxics and HTTP toxics.
+func (r *Route) Clear() *Route {
+	r.t.Helper()
+	toxics, err := r.Toxics()
+	if err != nil {
+		r.t.Fatalf("shriketest: getting the toxics on %s: %s", r.Prefix, err)
+	}
+	for _, t := range toxics {
+		if err := r.RemoveToxic(t.Name); err != nil {
+			r.t.Fatalf("shriketest: removing the %s toxic from %s: %s", t.Name, r.Prefix, err)
+		}
+	}
+	httpToxics, err := r.HTTPToxics()
+	if err != nil {
+		r.t.Fatalf("shriketest: getting the http toxics on %s: %s", r.Prefix, err)
+	}
+	for _, t := range httpToxics {
+		if err := r.RemoveHTTPToxic(t.Name); err != nil {
+			r.t.Fatalf("shriketest: removing the %s http toxic from %s: %s", t.Name, r.Prefix, err)
+		}
+	}
+	return r
+}
+
+func millis(d time.Duration) int64 {
+	return int64(d / time.Millisecond)
+}
@@ -0,0 +1,81 @@
+package shriketest
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHarness(t *testing.T) {
+	var (
+		h      *Harness
+		listen string
+	)
+	ok := t.Run("faults", func(t *testing.T) {
+		h = New(t, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			w.Write([]byte("ok"))
+		}))
+		get := func(path string) int {
+			t.Helper()
+			resp, err := http.Get(h.URL + path)
+			if err != nil {
+				t.Fatalf("getting %s: %s", path, err)
+			}
+			defer resp.Body.Close()
+			ioutil.ReadAll(resp.Body)
+			return resp.StatusCode
+		}
+
+		r := h.Route("/orders")
+		listen = r.Proxy.Listen
+		if code := get("/orders/1"); code != http.StatusOK {
+			t.Fatalf("got %d through the route, want 200", code)
+		}
+
+		start := time.Now()
+		r.Latency(100 * time.Millisecond)
+		if code := get("/orders/1"); code != http.StatusOK {
+			t.Fatalf("got %d with latency, want 200", code)
+		}
+		if took := time.Since(start); took < 100*time.Millisecond {
+			t.Errorf("request took %s with 100ms of latency", took)
+		}
+
+		r.Clear().Status(http.StatusServiceUnavailable)
+		if code := get("/orders/1"); code != http.StatusServiceUnavailable {
+			t.Errorf("got %d with a status toxic, want 503", code)
+		}
+
+		r.Clear().Down()
+		if code := get("/orders/1"); code != http.StatusBadGateway {
+			t.Errorf("got %d with the route down, want 502", code)
+		}
+		r.Up()
+		if code := get("/orders/1"); code != http.StatusOK {
+			t.Errorf("got %d with the route up again, want 200", code)
+		}
+		if code := get("/other"); code != http.StatusOK {
+			t.Errorf("got %d without a route, want 200", code)
+		}
+	})
+	if !ok {
+		return
+	}
+
+	// The subtest's cleanups have run, so nothing should be listening.
+	for _, addr := range []string{
+		strings.TrimPrefix(h.URL, "http://"),
+		h.Server.APIAddr(),
+		h.Server.ToxyAPIAddr(),
+		listen,
+		strings.TrimPrefix(h.Upstream.URL, "http://"),
+	} {
+		if conn, err := net.DialTimeout("tcp", addr, time.Second); err == nil {
+			conn.Close()
+			t.Errorf("%s is still listening after cleanup", addr)
+		}
+	}
+}
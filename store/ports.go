@@ -0,0 +1,94 @@
+package store
+
+import (
+	"errors"
+	"hash/adler32"
+	"sync"
+)
+
+// ErrPortsExhausted is returned when every port in the range is in use.
+var ErrPortsExhausted = errors.New("no free Toxiproxy ports left in the port range")
+
+// NewPortAllocator returns an allocator for ports in the range min <= x <= max.
+func NewPortAllocator(min, max int) *PortAllocator {
+	return &PortAllocator{
+		min:      min,
+		max:      max,
+		ports:    map[string]int{},
+		names:    map[int]string{},
+		unusable: map[int]bool{},
+	}
+}
+
+// PortAllocator hands out Toxiproxy listen ports to proxy names without collisions.
+type PortAllocator struct {
+	mu    sync.Mutex
+	min   int
+	max   int
+	ports map[string]int
+	names map[int]string
+	// ports found to be in use by something else
+	unusable map[int]bool
+}
+
+// Allocate a port for the proxy name. The search starts from a checksum of the
+// name so a name tends to get the same port each time, and moves on to the next
+// free port when that one is taken.
+func (a *PortAllocator) Allocate(name string) (int, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if p, ok := a.ports[name]; ok {
+		return p, nil
+	}
+
+	size := a.max - a.min + 1
+	start := int(adler32.Checksum([]byte(name)) % uint32(size))
+	for i := 0; i < size; i++ {
+		p := a.min + (start+i)%size
+		if _, used := a.names[p]; !used && !a.unusable[p] {
+			a.ports[name] = p
+			a.names[p] = name
+			return p, nil
+		}
+	}
+	return 0, ErrPortsExhausted
+}
+
+// Reserve the port for the proxy name, such as for a proxy that already exists.
+// Any other name holding the port loses it.
+func (a *PortAllocator) Reserve(name string, port int) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.release(name)
+	if previous, ok := a.names[port]; ok {
+		a.release(previous)
+	}
+	delete(a.unusable, port)
+	a.ports[name] = port
+	a.names[port] = name
+}
+
+// Release the port held by the proxy name.
+func (a *PortAllocator) Release(name string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.release(name)
+}
+
+// Unusable releases the port held by the proxy name and stops it being handed
+// out again, as when another process is already listening on it.
+func (a *PortAllocator) Unusable(name string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if p, ok := a.ports[name]; ok {
+		a.unusable[p] = true
+	}
+	a.release(name)
+}
+
+func (a *PortAllocator) release(name string) {
+	if p, ok := a.ports[name]; ok {
+		delete(a.names, p)
+		delete(a.ports, name)
+	}
+}
@@ -0,0 +1,90 @@
+package store_test
+
+import (
+	"fmt"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/richardbolt/shrike/store"
+)
+
+var _ = Describe("PortAllocator", func() {
+	// nameFor returns a proxy name whose search starts at port in a fresh
+	// allocator for the range.
+	nameFor := func(min, max, port int) string {
+		for i := 0; ; i++ {
+			name := fmt.Sprintf("route-%d", i)
+			if p, _ := store.NewPortAllocator(min, max).Allocate(name); p == port {
+				return name
+			}
+		}
+	}
+
+	It("gives a name the same port each time", func() {
+		a := store.NewPortAllocator(10000, 10999)
+		p, err := a.Allocate("orders")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(p).To(BeNumerically(">=", 10000))
+		Expect(p).To(BeNumerically("<=", 10999))
+		Expect(a.Allocate("orders")).To(Equal(p))
+		Expect(store.NewPortAllocator(10000, 10999).Allocate("orders")).To(Equal(p))
+	})
+
+	It("hands out every port in the range before running out", func() {
+		a := store.NewPortAllocator(100, 104)
+		seen := map[int]bool{}
+		for i := 0; i < 5; i++ {
+			p, err := a.Allocate(fmt.Sprintf("route-%d", i))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(seen).NotTo(HaveKey(p))
+			seen[p] = true
+		}
+		_, err := a.Allocate("one-too-many")
+		Expect(err).To(Equal(store.ErrPortsExhausted))
+	})
+
+	It("wraps around to the bottom of the range", func() {
+		name := nameFor(100, 104, 104)
+		a := store.NewPortAllocator(100, 104)
+		a.Reserve("other", 104)
+		Expect(a.Allocate(name)).To(Equal(100))
+	})
+
+	It("hands out released ports again", func() {
+		a := store.NewPortAllocator(100, 100)
+		Expect(a.Allocate("first")).To(Equal(100))
+		_, err := a.Allocate("second")
+		Expect(err).To(Equal(store.ErrPortsExhausted))
+		a.Release("first")
+		Expect(a.Allocate("second")).To(Equal(100))
+	})
+
+	It("moves a reserved port from its previous holder", func() {
+		a := store.NewPortAllocator(100, 101)
+		name := nameFor(100, 101, 100)
+		Expect(a.Allocate(name)).To(Equal(100))
+		a.Reserve("existing", 100)
+		// The previous holder no longer has a port, so gets the next one.
+		Expect(a.Allocate(name)).To(Equal(101))
+		a.Release(name)
+		Expect(a.Allocate("another")).To(Equal(101))
+	})
+
+	It("skips ports marked unusable", func() {
+		a := store.NewPortAllocator(100, 101)
+		name := nameFor(100, 101, 100)
+		Expect(a.Allocate(name)).To(Equal(100))
+		a.Unusable(name)
+		Expect(a.Allocate(name)).To(Equal(101))
+		a.Release(name)
+		Expect(a.Allocate(name)).To(Equal(101))
+		_, err := a.Allocate("another")
+		Expect(err).To(Equal(store.ErrPortsExhausted))
+
+		// A proxy found listening on the port makes it usable again.
+		a.Reserve("existing", 100)
+		a.Release("existing")
+		Expect(a.Allocate("another")).To(Equal(100))
+	})
+})
@@ -2,95 +2,403 @@ package store
 
 import (
 	"fmt"
-	"hash/adler32"
+	"net"
+	"net/http"
 	"net/url"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 
 	toxy "github.com/Shopify/toxiproxy/client"
 	"github.com/armon/go-radix"
 )
 
-// New returns a store of proxies.
-// Proxies are stored by path even though proxies are named sans forward slashes.
-func New(root url.URL, sep string) *ProxyStore {
-	return &ProxyStore{
-		root: root,
-		sep:  sep,
-		tree: radix.New(),
+// Match types for a route's path. Routes are tried in this order: exact paths,
+// then the longest matching prefix, then globs and finally regular expressions.
+const (
+	MatchPrefix = "prefix"
+	MatchExact  = "exact"
+	MatchGlob   = "glob"
+	MatchRegex  = "regex"
+)
+
+// New returns a store of routes.
+// Prefix and exact routes are stored by path in a radix tree for matching, glob
+// and regex routes in a list that is tried afterwards, and all by name for lookups.
+// Unmatched requests go to the upstream for their host in hosts, or root.
+func New(root url.URL, hosts map[string]url.URL) *ProxyStore {
+	s := &ProxyStore{
+		root:  root,
+		hosts: hosts,
 	}
+	s.current.Store(&routeSet{
+		tree:   radix.New(),
+		routes: map[string]*Route{},
+	})
+	return s
 }
 
-// ProxyStore stores our proxies in an efficient fashion for path prefix matching
+// ProxyStore stores our routes in an efficient fashion for path prefix matching.
+// It's safe for concurrent use. Reads never block: they use the current set of
+// routes, which writers replace with an updated copy rather than changing.
 type ProxyStore struct {
-	root url.URL
-	sep  string
-	tree *radix.Tree
+	root    url.URL
+	hosts   map[string]url.URL
+	mu      sync.Mutex // held by writers
+	current atomic.Value
+}
+
+// routeSet is the routes at a point in time. It isn't changed once current.
+type routeSet struct {
+	tree     *radix.Tree
+	patterns []*Route
+	routes   map[string]*Route
 }
 
-// Add a proxy
-func (s *ProxyStore) Add(proxy *toxy.Proxy) {
-	s.tree.Insert(PathNameFrom(s.sep, proxy.Name), proxy)
+// Route is a Toxiproxy proxy and the requests that are sent through it.
+// Several routes can share a prefix, in which case the Host, Methods, Headers
+// and Query predicates are evaluated in Priority order, highest first.
+// Prefix holds the exact path, glob or regular expression for those Match types.
+type Route struct {
+	Name     string
+	Prefix   string
+	Match    string
+	Host     string
+	Methods  []string
+	Headers  map[string]string
+	Query    map[string]string
+	Priority int
+	Upstream url.URL
+	Proxy    *toxy.Proxy
+	// Dial is the address the proxy is reached on, its listen address when empty.
+	Dial   string
+	regexp *regexp.Regexp
 }
 
-// Get a proxy by path prefix
-func (s *ProxyStore) Get(path string) *toxy.Proxy {
-	p, m := s.tree.Get(path)
-	if m == false {
-		return nil
+// ValidatePattern checks the path, prefix or pattern is valid for the match type.
+func ValidatePattern(match, pattern string) error {
+	switch match {
+	case "", MatchPrefix, MatchExact:
+	case MatchGlob:
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("glob %q: %s", pattern, err)
+		}
+	case MatchRegex:
+		if _, err := regexp.Compile(pattern); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("match must be one of %s, %s, %s or %s", MatchPrefix, MatchExact, MatchGlob, MatchRegex)
 	}
-	return p.(*toxy.Proxy)
+	return nil
 }
 
-// Delete a proxy
-func (s *ProxyStore) Delete(proxy *toxy.Proxy) {
-	s.tree.Delete(PathNameFrom(s.sep, proxy.Name))
+// matchesPath reports whether a glob or regex route matches the path.
+func (r *Route) matchesPath(p string) bool {
+	if r.Match == MatchRegex {
+		return r.regexp.MatchString(p)
+	}
+	ok, _ := path.Match(r.Prefix, p)
+	return ok
+}
+
+// isPattern reports whether the route is matched by pattern rather than the tree.
+func (r *Route) isPattern() bool {
+	return r.Match == MatchGlob || r.Match == MatchRegex
 }
 
-// ToMap returns the Proxy store entries as a map of proxies
-func (s *ProxyStore) ToMap() map[string]*toxy.Proxy {
-	proxies := map[string]*toxy.Proxy{}
-	for k, v := range s.tree.ToMap() {
-		proxies[k] = v.(*toxy.Proxy)
+// Matches reports whether the request meets the route's predicates. An empty
+// header or query value only requires the header or parameter to be present.
+func (r *Route) Matches(req *http.Request) bool {
+	if r.Host != "" && !strings.EqualFold(r.Host, RequestHost(req)) {
+		return false
+	}
+	if len(r.Methods) > 0 {
+		found := false
+		for _, m := range r.Methods {
+			if strings.EqualFold(m, req.Method) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
 	}
-	return proxies
+	for k, v := range r.Headers {
+		values, ok := req.Header[http.CanonicalHeaderKey(k)]
+		if !ok || !contains(values, v) {
+			return false
+		}
+	}
+	if len(r.Query) > 0 {
+		query := req.URL.Query()
+		for k, v := range r.Query {
+			values, ok := query[k]
+			if !ok || !contains(values, v) {
+				return false
+			}
+		}
+	}
+	return true
 }
 
-// Match returns a url.URL and a boolean to indicate whether we matched or are using the default.
-func (s *ProxyStore) Match(path string) (url.URL, bool) {
-	_, p, m := s.tree.LongestPrefix(path)
-	if !m {
-		return s.root, false
+// predicates is the number of predicates on the route, used to try more specific routes first.
+func (r *Route) predicates() int {
+	n := len(r.Headers) + len(r.Query)
+	if len(r.Methods) > 0 {
+		n++
 	}
-	proxy := p.(*toxy.Proxy)
-	// Hard coding some http in here. TODO: see if we can make this work HTTPS as well.
-	if u, err := url.Parse(fmt.Sprintf("http://%s", proxy.Listen)); err == nil {
-		return *u, true
+	if r.Host != "" {
+		n++
 	}
-	return s.root, false
+	return n
 }
 
-// NumFrom string to map a path or proxy name to a port number.
-// The number returned will be in the range min < x < 65536
-func NumFrom(s string) uint16 {
-	var min uint16 = 10000
-	x := uint16(adler32.Checksum([]byte(s)) / 65536)
-	if x < min {
-		for {
-			x = x * 2
-			if x > min {
-				break
+func contains(values []string, v string) bool {
+	if v == "" {
+		return true
+	}
+	for _, value := range values {
+		if value == v {
+			return true
+		}
+	}
+	return false
+}
+
+// Add a route, replacing any route by the same name. The route must not be
+// changed afterwards.
+func (s *ProxyStore) Add(r *Route) error {
+	if err := ValidatePattern(r.Match, r.Prefix); err != nil {
+		return err
+	}
+	if r.Match == MatchRegex {
+		r.regexp = regexp.MustCompile(r.Prefix)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rs := s.load().copy()
+	rs.add(r)
+	s.current.Store(rs)
+	return nil
+}
+
+// Delete a route by name
+func (s *ProxyStore) Delete(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rs := s.load().copy()
+	rs.delete(name)
+	s.current.Store(rs)
+}
+
+// load the current routes.
+func (s *ProxyStore) load() *routeSet {
+	return s.current.Load().(*routeSet)
+}
+
+// copy the routes for changing. The routes themselves and their candidate
+// lists are shared, as they're replaced rather than changed.
+func (s *routeSet) copy() *routeSet {
+	c := &routeSet{
+		tree:     radix.NewFromMap(s.tree.ToMap()),
+		patterns: append([]*Route{}, s.patterns...),
+		routes:   make(map[string]*Route, len(s.routes)),
+	}
+	for k, v := range s.routes {
+		c.routes[k] = v
+	}
+	return c
+}
+
+func (s *routeSet) add(r *Route) {
+	s.delete(r.Name)
+	s.routes[r.Name] = r
+
+	if r.isPattern() {
+		s.patterns = append(s.patterns, r)
+		sort.SliceStable(s.patterns, func(i, j int) bool {
+			a, b := s.patterns[i], s.patterns[j]
+			if (a.Match == MatchGlob) != (b.Match == MatchGlob) {
+				return a.Match == MatchGlob
 			}
+			return before(a, b)
+		})
+		return
+	}
+
+	candidates := []*Route{r}
+	if v, ok := s.tree.Get(r.Prefix); ok {
+		candidates = append(candidates, v.([]*Route)...)
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return before(candidates[i], candidates[j])
+	})
+	s.tree.Insert(r.Prefix, candidates)
+}
+
+// before orders routes of the same kind by priority, then the most predicates, then name.
+func before(a, b *Route) bool {
+	if a.Priority != b.Priority {
+		return a.Priority > b.Priority
+	}
+	if a.predicates() != b.predicates() {
+		return a.predicates() > b.predicates()
+	}
+	return a.Name < b.Name
+}
+
+// Get a route by name
+func (s *ProxyStore) Get(name string) *Route {
+	return s.load().routes[name]
+}
+
+func (s *routeSet) delete(name string) {
+	r, ok := s.routes[name]
+	if !ok {
+		return
+	}
+	delete(s.routes, name)
+
+	if r.isPattern() {
+		patterns := []*Route{}
+		for _, p := range s.patterns {
+			if p.Name != name {
+				patterns = append(patterns, p)
+			}
+		}
+		s.patterns = patterns
+		return
+	}
+
+	v, _ := s.tree.Get(r.Prefix)
+	candidates := []*Route{}
+	for _, c := range v.([]*Route) {
+		if c.Name != name {
+			candidates = append(candidates, c)
 		}
 	}
-	return x
+	if len(candidates) == 0 {
+		s.tree.Delete(r.Prefix)
+	} else {
+		s.tree.Insert(r.Prefix, candidates)
+	}
+}
+
+// ToMap returns the store entries as a map of routes by name
+func (s *ProxyStore) ToMap() map[string]*Route {
+	routes := map[string]*Route{}
+	for k, v := range s.load().routes {
+		routes[k] = v
+	}
+	return routes
 }
 
+// Match returns the matched route, a url.URL and a boolean to indicate whether we matched or are using the default.
+// An exact path wins, then the longest matching prefix, then globs and then
+// regular expressions, each with a route whose predicates match the request.
+func (s *ProxyStore) Match(req *http.Request) (*Route, url.URL, bool) {
+	rs := s.load()
+	p := req.URL.Path
+	prefixes := [][]*Route{}
+	rs.tree.WalkPath(p, func(_ string, v interface{}) bool {
+		prefixes = append(prefixes, v.([]*Route))
+		return false
+	})
+	if v, ok := rs.tree.Get(p); ok {
+		for _, r := range v.([]*Route) {
+			if r.Match == MatchExact && r.Matches(req) {
+				return r, r.listenURL(), true
+			}
+		}
+	}
+	for i := len(prefixes) - 1; i >= 0; i-- {
+		for _, r := range prefixes[i] {
+			if r.Match != MatchExact && r.Matches(req) {
+				return r, r.listenURL(), true
+			}
+		}
+	}
+	for _, r := range rs.patterns {
+		if r.matchesPath(p) && r.Matches(req) {
+			return r, r.listenURL(), true
+		}
+	}
+	if u, ok := s.hosts[strings.ToLower(RequestHost(req))]; ok {
+		return nil, u, false
+	}
+	return nil, s.root, false
+}
+
+// listenURL is the URL of the route's proxy listener. Toxiproxy only passes TCP
+// through so TLS to an https upstream is made to the listener.
+func (r *Route) listenURL() url.URL {
+	scheme := "http"
+	if r.Upstream.Scheme == "https" {
+		scheme = "https"
+	}
+	if r.Dial != "" {
+		return url.URL{Scheme: scheme, Host: r.Dial}
+	}
+	return url.URL{Scheme: scheme, Host: r.Proxy.Listen}
+}
+
+// RequestHost is the request's host without any port.
+func RequestHost(req *http.Request) string {
+	if h, _, err := net.SplitHostPort(req.Host); err == nil {
+		return h
+	}
+	return req.Host
+}
+
+// Escape is the character proxy names escape itself and each character of the
+// separator with, as ~ followed by two hex digits, so names convert back to
+// paths losslessly.
+const Escape = "~"
+
 // ProxyNameFrom returns the proxy name normalized from str
 func ProxyNameFrom(sep, str string) string {
+	var name strings.Builder
+	for i := 0; i < len(str); i++ {
+		switch {
+		case str[i] == '/':
+			name.WriteString(sep)
+		case str[i] == Escape[0] || strings.IndexByte(sep, str[i]) >= 0:
+			fmt.Fprintf(&name, "%s%02X", Escape, str[i])
+		default:
+			name.WriteByte(str[i])
+		}
+	}
+	return name.String()
+}
+
+// LegacyProxyNameFrom returns the proxy name earlier versions gave str, with
+// nothing escaped, so their proxies can be found again.
+func LegacyProxyNameFrom(sep, str string) string {
 	return strings.Replace(str, "/", sep, -1)
 }
 
 // PathNameFrom returns the path name from proxy name str
 func PathNameFrom(sep, str string) string {
-	return strings.Replace(str, sep, "/", -1)
+	return unescapeString(strings.Replace(str, sep, "/", -1))
+}
+
+func unescapeString(s string) string {
+	b := []byte{}
+	for i := 0; i < len(s); i++ {
+		if strings.HasPrefix(s[i:], Escape) && i+len(Escape)+2 <= len(s) {
+			if c, err := strconv.ParseUint(s[i+len(Escape):i+len(Escape)+2], 16, 8); err == nil {
+				b = append(b, byte(c))
+				i += len(Escape) + 1
+				continue
+			}
+		}
+		b = append(b, s[i])
+	}
+	return string(b)
 }
@@ -0,0 +1,299 @@
+package store_test
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+
+	toxy "github.com/Shopify/toxiproxy/client"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/richardbolt/shrike/store"
+)
+
+func route(name, prefix, match string, port int) *store.Route {
+	return &store.Route{
+		Name:     name,
+		Prefix:   prefix,
+		Match:    match,
+		Upstream: url.URL{Scheme: "http", Host: "upstream"},
+		Proxy:    &toxy.Proxy{Name: name, Listen: fmt.Sprintf("127.0.0.1:%d", port)},
+	}
+}
+
+var _ = Describe("ProxyStore", func() {
+	var s *store.ProxyStore
+
+	BeforeEach(func() {
+		s = store.New(url.URL{Scheme: "http", Host: "root"}, nil)
+	})
+
+	match := func(path string) (string, url.URL) {
+		r, u, ok := s.Match(httptest.NewRequest("GET", path, nil))
+		if !ok {
+			return "", u
+		}
+		return r.Name, u
+	}
+
+	It("matches the longest prefix", func() {
+		Expect(s.Add(route("orders", "/orders", store.MatchPrefix, 10001))).To(Succeed())
+		Expect(s.Add(route("orders-v1", "/orders/v1", store.MatchPrefix, 10002))).To(Succeed())
+
+		name, u := match("/orders/v1/42")
+		Expect(name).To(Equal("orders-v1"))
+		Expect(u.Host).To(Equal("127.0.0.1:10002"))
+		name, _ = match("/orders/v2")
+		Expect(name).To(Equal("orders"))
+	})
+
+	It("prefers an exact path to a prefix", func() {
+		Expect(s.Add(route("prefix", "/health", store.MatchPrefix, 10001))).To(Succeed())
+		Expect(s.Add(route("exact", "/health", store.MatchExact, 10002))).To(Succeed())
+
+		name, _ := match("/health")
+		Expect(name).To(Equal("exact"))
+		name, _ = match("/health/deep")
+		Expect(name).To(Equal("prefix"))
+	})
+
+	It("sends unmatched requests to the root", func() {
+		Expect(s.Add(route("orders", "/orders", store.MatchPrefix, 10001))).To(Succeed())
+
+		name, u := match("/payments")
+		Expect(name).To(BeEmpty())
+		Expect(u.Host).To(Equal("root"))
+	})
+
+	It("stops matching deleted routes", func() {
+		Expect(s.Add(route("orders", "/orders", store.MatchPrefix, 10001))).To(Succeed())
+		Expect(s.Add(route("glob", "/users/*", store.MatchGlob, 10002))).To(Succeed())
+		s.Delete("orders")
+		s.Delete("glob")
+
+		name, _ := match("/orders")
+		Expect(name).To(BeEmpty())
+		name, _ = match("/users/42")
+		Expect(name).To(BeEmpty())
+		Expect(s.ToMap()).To(BeEmpty())
+	})
+
+	It("replaces a route by the same name", func() {
+		Expect(s.Add(route("orders", "/orders", store.MatchPrefix, 10001))).To(Succeed())
+		Expect(s.Add(route("orders", "/orders", store.MatchPrefix, 10002))).To(Succeed())
+
+		_, u := match("/orders")
+		Expect(u.Host).To(Equal("127.0.0.1:10002"))
+		Expect(s.ToMap()).To(HaveLen(1))
+	})
+
+	Context("with method, header and query predicates", func() {
+		request := func(method, path string, headers map[string]string) string {
+			req := httptest.NewRequest(method, path, nil)
+			for k, v := range headers {
+				req.Header.Set(k, v)
+			}
+			r, _, ok := s.Match(req)
+			if !ok {
+				return ""
+			}
+			return r.Name
+		}
+
+		It("only matches routes whose predicates the request meets", func() {
+			creates := route("creates", "/orders", store.MatchPrefix, 10001)
+			creates.Methods = []string{"post"}
+			canary := route("canary", "/orders", store.MatchPrefix, 10002)
+			canary.Headers = map[string]string{"x-tenant": "canary"}
+			debug := route("debug", "/orders", store.MatchPrefix, 10003)
+			debug.Query = map[string]string{"debug": ""}
+			Expect(s.Add(creates)).To(Succeed())
+			Expect(s.Add(canary)).To(Succeed())
+			Expect(s.Add(debug)).To(Succeed())
+
+			Expect(request("POST", "/orders", nil)).To(Equal("creates"))
+			Expect(request("GET", "/orders", map[string]string{"X-Tenant": "canary"})).To(Equal("canary"))
+			Expect(request("GET", "/orders", map[string]string{"X-Tenant": "other"})).To(BeEmpty())
+			Expect(request("GET", "/orders?debug", nil)).To(Equal("debug"))
+			Expect(request("GET", "/orders?debug=1", nil)).To(Equal("debug"))
+			Expect(request("GET", "/orders", nil)).To(BeEmpty())
+		})
+
+		It("tries routes by priority, then the most predicates", func() {
+			plain := route("plain", "/orders", store.MatchPrefix, 10001)
+			posts := route("posts", "/orders", store.MatchPrefix, 10002)
+			posts.Methods = []string{"POST"}
+			Expect(s.Add(plain)).To(Succeed())
+			Expect(s.Add(posts)).To(Succeed())
+			Expect(request("POST", "/orders", nil)).To(Equal("posts"))
+			Expect(request("GET", "/orders", nil)).To(Equal("plain"))
+
+			plain = route("plain", "/orders", store.MatchPrefix, 10001)
+			plain.Priority = 1
+			Expect(s.Add(plain)).To(Succeed())
+			Expect(request("POST", "/orders", nil)).To(Equal("plain"))
+		})
+
+		It("falls back to a shorter prefix when no route on the longest matches", func() {
+			Expect(s.Add(route("orders", "/orders", store.MatchPrefix, 10001))).To(Succeed())
+			creates := route("creates", "/orders/v1", store.MatchPrefix, 10002)
+			creates.Methods = []string{"POST"}
+			Expect(s.Add(creates)).To(Succeed())
+
+			Expect(request("POST", "/orders/v1", nil)).To(Equal("creates"))
+			Expect(request("GET", "/orders/v1", nil)).To(Equal("orders"))
+		})
+	})
+
+	Context("with glob and regex paths", func() {
+		It("tries globs, then regular expressions, after prefixes", func() {
+			Expect(s.Add(route("users", "/users/*/orders", store.MatchGlob, 10001))).To(Succeed())
+			Expect(s.Add(route("versioned", "^/v[0-9]+/users/", store.MatchRegex, 10002))).To(Succeed())
+			Expect(s.Add(route("v1", "/v1/users/admin", store.MatchPrefix, 10003))).To(Succeed())
+
+			name, _ := match("/users/42/orders")
+			Expect(name).To(Equal("users"))
+			name, _ = match("/users/42/orders/1")
+			Expect(name).To(BeEmpty())
+			name, _ = match("/v2/users/42")
+			Expect(name).To(Equal("versioned"))
+			name, _ = match("/v1/users/admin")
+			Expect(name).To(Equal("v1"))
+		})
+
+		It("prefers a glob to a regular expression", func() {
+			Expect(s.Add(route("regex", "^/users/", store.MatchRegex, 10001))).To(Succeed())
+			Expect(s.Add(route("glob", "/users/*", store.MatchGlob, 10002))).To(Succeed())
+
+			name, _ := match("/users/42")
+			Expect(name).To(Equal("glob"))
+			name, _ = match("/users/42/orders")
+			Expect(name).To(Equal("regex"))
+		})
+
+		It("rejects patterns that aren't valid", func() {
+			Expect(store.ValidatePattern(store.MatchGlob, "/users/[")).NotTo(Succeed())
+			Expect(store.ValidatePattern(store.MatchRegex, "^/users/(")).NotTo(Succeed())
+			Expect(store.ValidatePattern("fuzzy", "/users")).NotTo(Succeed())
+			Expect(s.Add(route("bad", "^/users/(", store.MatchRegex, 10001))).NotTo(Succeed())
+			Expect(s.ToMap()).To(BeEmpty())
+		})
+	})
+
+	Context("routing by host", func() {
+		BeforeEach(func() {
+			s = store.New(url.URL{Scheme: "http", Host: "root"}, map[string]url.URL{
+				"api.example.com": {Scheme: "http", Host: "api"},
+			})
+		})
+
+		request := func(host, path string) (string, url.URL) {
+			req := httptest.NewRequest("GET", path, nil)
+			req.Host = host
+			r, u, ok := s.Match(req)
+			if !ok {
+				return "", u
+			}
+			return r.Name, u
+		}
+
+		It("only matches routes for the request's host, ignoring case and port", func() {
+			api := route("api", "/orders", store.MatchPrefix, 10001)
+			api.Host = "API.example.com"
+			Expect(s.Add(api)).To(Succeed())
+
+			name, _ := request("api.example.com:8080", "/orders")
+			Expect(name).To(Equal("api"))
+			name, _ = request("web.example.com", "/orders")
+			Expect(name).To(BeEmpty())
+		})
+
+		It("sends unmatched requests to the upstream for their host", func() {
+			_, u := request("api.example.com", "/orders")
+			Expect(u.Host).To(Equal("api"))
+			_, u = request("web.example.com", "/orders")
+			Expect(u.Host).To(Equal("root"))
+		})
+	})
+
+	Context("naming proxies after paths", func() {
+		It("converts names back to the paths they came from", func() {
+			for _, c := range []struct{ sep, path, name string }{
+				{"__", "/orders/v1", "__orders__v1"},
+				{"__", "/my_service", "__my~5Fservice"},
+				{"__", "/a__b/c", "__a~5F~5Fb__c"},
+				{"__", "/a_/b", "__a~5F__b"},
+				{"__", "/orders_/v1", "__orders~5F__v1"},
+				{"__", "/_/", "__~5F__"},
+				{"__", "/~/a", "__~7E__a"},
+				{"__", "/a~5F/b", "__a~7E5F__b"},
+				{"__", "/_~_/", "__~5F~7E~5F__"},
+				{"-", "/a-/b-c", "-a~2D-b~2Dc"},
+				{"-", "/a~/-", "-a~7E-~2D"},
+			} {
+				Expect(store.ProxyNameFrom(c.sep, c.path)).To(Equal(c.name), c.path)
+				Expect(store.PathNameFrom(c.sep, c.name)).To(Equal(c.path), c.name)
+			}
+		})
+
+		It("gives the unescaped names of earlier versions", func() {
+			Expect(store.LegacyProxyNameFrom("__", "/my_service/v1")).To(Equal("__my_service__v1"))
+		})
+	})
+
+	Context("used concurrently", func() {
+		It("matches while routes are added and deleted", func() {
+			Expect(s.Add(route("stable", "/stable", store.MatchPrefix, 10000))).To(Succeed())
+
+			const writers, readers, rounds = 4, 8, 200
+			var wg sync.WaitGroup
+			for w := 0; w < writers; w++ {
+				wg.Add(1)
+				go func(w int) {
+					defer GinkgoRecover()
+					defer wg.Done()
+					for i := 0; i < rounds; i++ {
+						name := fmt.Sprintf("churn-%d-%d", w, i%10)
+						match := store.MatchPrefix
+						if i%3 == 0 {
+							match = store.MatchGlob
+						}
+						Expect(s.Add(route(name, fmt.Sprintf("/stable/churn/%d/%d", w, i%10), match, 20000+i))).To(Succeed())
+						if i%2 == 0 {
+							s.Delete(name)
+						}
+					}
+				}(w)
+			}
+			for r := 0; r < readers; r++ {
+				wg.Add(1)
+				go func() {
+					defer GinkgoRecover()
+					defer wg.Done()
+					for i := 0; i < rounds; i++ {
+						name, _ := match("/stable/elsewhere")
+						Expect(name).To(Equal("stable"))
+						Expect(s.Get("stable")).NotTo(BeNil())
+						Expect(s.ToMap()).To(HaveKey("stable"))
+						match("/stable/churn/1/1")
+					}
+				}()
+			}
+			wg.Wait()
+
+			for w := 0; w < writers; w++ {
+				for i := 0; i < 10; i++ {
+					name := fmt.Sprintf("churn-%d-%d", w, i)
+					r := s.Get(name)
+					if i%2 == 0 {
+						Expect(r).To(BeNil())
+					} else {
+						Expect(r).NotTo(BeNil())
+					}
+				}
+			}
+		})
+	})
+})